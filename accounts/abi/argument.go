@@ -21,8 +21,6 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
-
-	"github.com/google/gofuzz"
 )
 
 // Argument holds the name of the argument and the corresponding type.
@@ -35,19 +33,24 @@ type Argument struct {
 
 type Arguments []Argument
 
+// ArgumentMarshaling is the json form of an argument, tuples list their fields
+// as components.
+type ArgumentMarshaling struct {
+	Name       string
+	Type       string
+	Components []ArgumentMarshaling
+	Indexed    bool
+}
+
 // UnmarshalJSON implements json.Unmarshaler interface
 func (argument *Argument) UnmarshalJSON(data []byte) error {
-	var extarg struct {
-		Name    string
-		Type    string
-		Indexed bool
-	}
+	var extarg ArgumentMarshaling
 	err := json.Unmarshal(data, &extarg)
 	if err != nil {
 		return fmt.Errorf("argument json err: %v", err)
 	}
 
-	argument.Type, err = NewType(extarg.Type)
+	argument.Type, err = newType(extarg.Type, extarg.Components)
 	if err != nil {
 		return err
 	}
@@ -238,87 +241,19 @@ func (arguments Arguments) Pack(args ...interface{}) ([]byte, error) {
 	if len(args) != len(abiArgs) {
 		return nil, fmt.Errorf("argument count mismatch: %d for %d", len(args), len(abiArgs))
 	}
-	// variable input is the output appended at the end of packed
-	// output. This is used for strings and bytes types input.
-	var variableInput []byte
-
-	// input offset is the bytes offset for packed output
-	inputOffset := 0
-	for _, abiArg := range abiArgs {
-		if abiArg.Type.T == ArrayTy {
-			inputOffset += 32 * abiArg.Type.Size
-		} else {
-			inputOffset += 32
-		}
-	}
-	var ret []byte
+	// the arguments are encoded like the fields of a tuple: static values
+	// in place, dynamic ones (strings, bytes, slices and anything holding
+	// them) in the tail behind an offset
+	types := make([]*Type, len(abiArgs))
+	values := make([][]byte, len(args))
 	for i, a := range args {
-		input := abiArgs[i]
-		// pack the input
-		packed, err := input.Type.pack(reflect.ValueOf(a))
-		if err != nil {
-			return nil, err
-		}
-		// check for a slice type (string, bytes, slice)
-		if input.Type.requiresLengthPrefix() {
-			// calculate the offset
-			offset := inputOffset + len(variableInput)
-			// set the offset
-			ret = append(ret, packNum(reflect.ValueOf(offset))...)
-			// Append the packed output to the variable input. The variable input
-			// will be appended at the end of the input.
-			variableInput = append(variableInput, packed...)
-		} else {
-			// append the packed value to the input
-			ret = append(ret, packed...)
-		}
-	}
-	// append the variable input at the end of the packed input
-	ret = append(ret, variableInput...)
-
-	return ret, nil
-}
-
-func (arguments Arguments) Fuzz(fuzzer *fuzz.Fuzzer) ([]byte, error) {
-	// Make sure arguments match up and pack them
-	abiArgs := arguments
-	// variable input is the output appended at the end of fuzzed
-	// output. This is used for strings and bytes types input.
-	var variableInput []byte
-
-	// input offset is the bytes offset for fuzzed output
-	inputOffset := 0
-	for _, abiArg := range abiArgs {
-		if abiArg.Type.T == ArrayTy {
-			inputOffset += 32 * abiArg.Type.Size
-		} else {
-			inputOffset += 32
-		}
-	}
-	var ret []byte
-	for _, input := range abiArgs {
-		// pack the input
-		fuzzed, err := input.Type.fuzz(fuzzer)
+		packed, err := abiArgs[i].Type.pack(reflect.ValueOf(a))
 		if err != nil {
 			return nil, err
 		}
-		if input.Type.requiresLengthPrefix() {
-			// calculate the offset
-			offset := inputOffset + len(variableInput)
-			// set the offset
-			ret = append(ret, packNum(reflect.ValueOf(offset))...)
-			// Append the packed output to the variable input. The variable input
-			// will be appended at the end of the input.
-			variableInput = append(variableInput, fuzzed...)
-		} else {
-			// append the fuzzed value to the input
-			ret = append(ret, fuzzed...)
-		}
+		types[i], values[i] = &abiArgs[i].Type, packed
 	}
-	// append the variable input at the end of the packed input
-	ret = append(ret, variableInput...)
-
-	return ret, nil
+	return encodeSequence(types, values), nil
 }
 
 // capitalise makes the first character of a string upper case, also removing any
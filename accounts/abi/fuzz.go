@@ -17,7 +17,6 @@
 package abi
 
 import (
-	"crypto/rand"
 	"math/big"
 	"minievm/common"
 	"minievm/common/math"
@@ -26,32 +25,40 @@ import (
 	fuzz "github.com/google/gofuzz"
 )
 
-func packFuzzedBytesSlice(fuzzed []byte, fuzzer *fuzz.Fuzzer) []byte {
-	l := len(fuzzed) / 32
-	len := packNum(reflect.ValueOf(l))
-	return append(len, common.RightPadBytes(fuzzed, (l+31)/32*32)...)
+// FuzzMaxLength bounds the number of elements of fuzzed dynamic arrays and the
+// number of bytes of fuzzed strings and bytes.
+var FuzzMaxLength = 16
+
+// fuzzIntn returns a fuzzed number in [0, n).
+func fuzzIntn(fuzzer *fuzz.Fuzzer, n int) int {
+	var v uint32
+	fuzzer.Fuzz(&v)
+	return int(v % uint32(n))
+}
+
+// fuzzBytes returns size fuzzed bytes.
+func fuzzBytes(fuzzer *fuzz.Fuzzer, size int) []byte {
+	out := make([]byte, size)
+	for i := range out {
+		fuzzer.Fuzz(&out[i])
+	}
+	return out
 }
 
 // fuzzBytesSlice fuzzs the given bytes as [L, V] as the canonical representation
 // bytes slice
 func fuzzBytesSlice(t Type, fuzzer *fuzz.Fuzzer) []byte {
-	switch t.T {
-	case StringTy:
-		var str string
-		fuzzer.Fuzz(&str)
-		return packBytesSlice([]byte(str), len(str))
-	case BytesTy:
-		var byteslice []byte
-		fuzzer.Fuzz(&byteslice)
-		return packBytesSlice(byteslice, len(byteslice))
-	}
-	return []byte{}
+	size := fuzzIntn(fuzzer, FuzzMaxLength+1)
+	return packBytesSlice(fuzzBytes(fuzzer, size), size)
+}
+
+// fuzzFixedBytes fuzzs a bytesN or function value, right padded to 32 bytes.
+func fuzzFixedBytes(t Type, fuzzer *fuzz.Fuzzer) []byte {
+	return common.RightPadBytes(fuzzBytes(fuzzer, t.Size), 32)
 }
 
 func fuzzAddress(t Type, fuzzer *fuzz.Fuzzer) []byte {
-	var address [20]byte
-	fuzzer.Fuzz(&address)
-	return packElement(t, reflect.ValueOf(address))
+	return common.LeftPadBytes(fuzzBytes(fuzzer, 20), 32)
 }
 
 func fuzzBool(t Type, fuzzer *fuzz.Fuzzer) []byte {
@@ -69,69 +76,98 @@ func fuzzElement(t Type, fuzzer *fuzz.Fuzzer) []byte {
 	switch t.T {
 	case IntTy, UintTy:
 		return fuzzNum(t, fuzzer)
-	case StringTy:
+	case StringTy, BytesTy:
 		return fuzzBytesSlice(t, fuzzer)
 	case AddressTy:
-		return common.LeftPadBytes(fuzzAddress(t, fuzzer), 32)
+		return fuzzAddress(t, fuzzer)
 	case BoolTy:
 		return fuzzBool(t, fuzzer)
-	case BytesTy:
-		return fuzzBytesSlice(t, fuzzer)
 	case FixedBytesTy, FunctionTy:
-		return fuzzBytesSlice(t, fuzzer)
+		return fuzzFixedBytes(t, fuzzer)
 	default:
 		panic("abi fuzz: fatal error")
 	}
 }
 
-func genRandomInSpecialDist() *big.Int {
-	maxrange := int64(16)
-	chooseRange, err := rand.Int(rand.Reader, big.NewInt(4))
-	if err != nil {
-		//error handling
-	}
-
-	n, _ := rand.Int(rand.Reader, big.NewInt(maxrange))
+// genRandomInSpecialDist returns a size bits number near 0, 2^(size-1) or 2^size
+func genRandomInSpecialDist(size int, fuzzer *fuzz.Fuzzer) *big.Int {
+	maxrange := 16
+	n := big.NewInt(int64(fuzzIntn(fuzzer, maxrange)))
 	/*
-		[0, 2^256-1] split into 3 parts
-		[0, 2^10-1], [2^255-100, 2^255+99], [2^256-2^10, 2^256-1]
+		[0, 2^size-1] split into 4 parts
+		[0, 15], [2^(size-1)-16, 2^(size-1)-1], [2^(size-1), 2^(size-1)+15], [2^size-16, 2^size-1]
 	*/
-	// log.Printf("Mutating Storage %s\n", name)
-
-	if chooseRange.Cmp(big.NewInt(0)) == 0 {
-		// n = n
-	} else if chooseRange.Cmp(big.NewInt(1)) == 0 {
-		offset := new(big.Int)
-		offset.Exp(big.NewInt(2), big.NewInt(255), nil).Sub(offset, big.NewInt(maxrange))
-		n.Add(offset, n)
-	} else if chooseRange.Cmp(big.NewInt(2)) == 0 {
-		offset := new(big.Int)
-		offset.Exp(big.NewInt(2), big.NewInt(255), nil)
-		n.Add(offset, n)
-	} else if chooseRange.Cmp(big.NewInt(3)) == 0 {
-		offset := new(big.Int)
-		offset.Exp(big.NewInt(2), big.NewInt(256), nil).Sub(offset, big.NewInt(maxrange))
-		n.Add(offset, n)
+	switch fuzzIntn(fuzzer, 4) {
+	case 1:
+		n.Add(n, new(big.Int).Sub(math.BigPow(2, int64(size-1)), big.NewInt(int64(maxrange))))
+	case 2:
+		n.Add(n, math.BigPow(2, int64(size-1)))
+	case 3:
+		n.Add(n, new(big.Int).Sub(math.BigPow(2, int64(size)), big.NewInt(int64(maxrange))))
 	}
 	return n
 }
 
-// fuzzNum fuzzs the given number (using the reflect value) and will cast it to appropriate number representation
+// fuzzNum fuzzs a number of t.Size bits. Half of them are close to the bounds of
+// the type, signed numbers in the upper half of the range are negative.
 func fuzzNum(t Type, fuzzer *fuzz.Fuzzer) []byte {
-	switch t.Kind {
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		var value uint64
-		fuzzer.Fuzz(&value)
-		return U256(new(big.Int).SetUint64(value))
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		var value int64
-		fuzzer.Fuzz(&value)
-		return U256(big.NewInt(value))
-	case reflect.Ptr:
-		n := genRandomInSpecialDist()
-		return U256(n)
-	default:
-		panic("abi fuzz: fatal error")
+	var n *big.Int
+	if fuzzIntn(fuzzer, 2) == 0 {
+		n = genRandomInSpecialDist(t.Size, fuzzer)
+	} else {
+		n = new(big.Int).SetBytes(fuzzBytes(fuzzer, (t.Size+7)/8))
 	}
+	n.And(n, new(big.Int).Sub(math.BigPow(2, int64(t.Size)), common.Big1))
+	if t.T == IntTy && n.Bit(t.Size-1) == 1 {
+		n.Sub(n, math.BigPow(2, int64(t.Size)))
+	}
+	return U256(n)
+}
 
+// fuzz returns a fuzzed value of the type in its canonical encoding.
+func (t Type) fuzz(fuzzer *fuzz.Fuzzer) ([]byte, error) {
+	switch t.T {
+	case SliceTy:
+		size := fuzzIntn(fuzzer, FuzzMaxLength+1)
+		fuzzed, err := fuzzSequence(repeatType(t.Elem, size), fuzzer)
+		if err != nil {
+			return nil, err
+		}
+		return append(packNum(reflect.ValueOf(size)), fuzzed...), nil
+	case ArrayTy:
+		return fuzzSequence(repeatType(t.Elem, t.Size), fuzzer)
+	case TupleTy:
+		return fuzzSequence(t.TupleElems, fuzzer)
+	}
+	return fuzzElement(t, fuzzer), nil
+}
+
+func repeatType(t *Type, n int) []*Type {
+	types := make([]*Type, n)
+	for i := range types {
+		types[i] = t
+	}
+	return types
+}
+
+// fuzzSequence fuzzs values of types and encodes them as a tuple.
+func fuzzSequence(types []*Type, fuzzer *fuzz.Fuzzer) ([]byte, error) {
+	values := make([][]byte, len(types))
+	for i, t := range types {
+		fuzzed, err := t.fuzz(fuzzer)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = fuzzed
+	}
+	return encodeSequence(types, values), nil
+}
+
+// Fuzz returns fuzzed values of the arguments in their canonical encoding.
+func (arguments Arguments) Fuzz(fuzzer *fuzz.Fuzzer) ([]byte, error) {
+	types := make([]*Type, len(arguments))
+	for i := range arguments {
+		types[i] = &arguments[i].Type
+	}
+	return fuzzSequence(types, fuzzer)
 }
@@ -0,0 +1,146 @@
+package abi
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"minievm/common"
+	"minievm/common/math"
+)
+
+const fuzzTestABI = `[{"type":"function","name":"f","inputs":[
+	{"name":"s","type":"tuple","components":[{"name":"a","type":"uint256"},{"name":"b","type":"bytes"}]},
+	{"name":"x","type":"int8[][]"},
+	{"name":"c","type":"bytes4"},
+	{"name":"p","type":"uint16[2]"}
+]}]`
+
+func word(data []byte, offset int) *big.Int {
+	return new(big.Int).SetBytes(data[offset : offset+32])
+}
+
+func TestFuzzEncoding(t *testing.T) {
+	abi, err := JSON(strings.NewReader(fuzzTestABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := abi.Methods["f"]
+	if sig := method.Sig(); sig != "f((uint256,bytes),int8[][],bytes4,uint16[2])" {
+		t.Fatalf("signature mismatch: have %s", sig)
+	}
+	minInt8 := new(big.Int).Sub(math.BigPow(2, 256), big.NewInt(128))
+
+	fuzzer := fuzz.New()
+	for i := 0; i < 200; i++ {
+		calldata, err := method.Fuzz(fuzzer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := calldata[4:]
+
+		// head: tuple offset, array offset, bytes4, uint16[2] in place
+		if word(data, 0).Int64() != 5*32 {
+			t.Fatalf("tuple offset mismatch: %x", data)
+		}
+		if c := data[2*32 : 3*32]; !bytes.Equal(c[4:], make([]byte, 28)) {
+			t.Fatalf("bytes4 not right padded: %x", c)
+		}
+		for _, off := range []int{3 * 32, 4 * 32} {
+			if word(data, off).BitLen() > 16 {
+				t.Fatalf("uint16 out of range: %x", data[off:off+32])
+			}
+		}
+
+		// tuple: a in place, b as offset relative to the tuple
+		tuple := data[5*32:]
+		if word(tuple, 32).Int64() != 64 {
+			t.Fatalf("bytes offset mismatch: %x", tuple)
+		}
+		if n := word(tuple, 64).Int64(); n > int64(FuzzMaxLength) {
+			t.Fatalf("bytes too long: %d", n)
+		}
+
+		// int8[][]: every element sign extended from 8 bits
+		outer := data[word(data, 32).Int64():]
+		n := int(word(outer, 0).Int64())
+		if n > FuzzMaxLength {
+			t.Fatalf("array too long: %d", n)
+		}
+		for j := 0; j < n; j++ {
+			inner := outer[32+word(outer, 32+j*32).Int64():]
+			for k := 0; k < int(word(inner, 0).Int64()); k++ {
+				v := word(inner, 32+k*32)
+				if v.Cmp(big.NewInt(128)) >= 0 && v.Cmp(minInt8) < 0 {
+					t.Fatalf("int8 out of range: %x", v)
+				}
+			}
+		}
+	}
+}
+
+func TestPackTuple(t *testing.T) {
+	typ, err := newType("tuple", []ArgumentMarshaling{{Name: "a", Type: "uint256"}, {Name: "b", Type: "string"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ.String() != "(uint256,string)" {
+		t.Fatalf("type mismatch: have %s", typ)
+	}
+	value := struct {
+		A *big.Int
+		B string
+	}{big.NewInt(7), "hi"}
+	packed, err := typ.pack(reflect.ValueOf(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := common.Hex2Bytes("0000000000000000000000000000000000000000000000000000000000000007" +
+		"0000000000000000000000000000000000000000000000000000000000000040" +
+		"0000000000000000000000000000000000000000000000000000000000000002" +
+		"6869000000000000000000000000000000000000000000000000000000000000")
+	if !bytes.Equal(packed, want) {
+		t.Fatalf("pack mismatch:\nhave %x\nwant %x", packed, want)
+	}
+}
+
+func TestPackDynamicArguments(t *testing.T) {
+	abi, err := JSON(strings.NewReader(`[{"type":"function","name":"g","inputs":[
+		{"name":"a","type":"string[2]"},
+		{"name":"b","type":"uint256"},
+		{"name":"s","type":"tuple","components":[{"name":"a","type":"uint256"},{"name":"b","type":"string"}]}
+	]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := struct {
+		A *big.Int
+		B string
+	}{big.NewInt(1), "hi"}
+	packed, err := abi.Pack("g", [2]string{"x", "y"}, big.NewInt(7), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := []string{
+		// head: string[2] and the tuple are dynamic, behind offsets
+		"60", "07", "0120",
+		// string[2]: offsets relative to the array, then both strings
+		"40", "80", "01", "78", "01", "79",
+		// (uint256,string)
+		"01", "40", "02", "6869",
+	}
+	var want []byte
+	for i, w := range words {
+		if i == 6 || i == 8 || i == 12 {
+			want = append(want, common.RightPadBytes(common.Hex2Bytes(w), 32)...)
+		} else {
+			want = append(want, common.LeftPadBytes(common.Hex2Bytes(w), 32)...)
+		}
+	}
+	if !bytes.Equal(packed[4:], want) {
+		t.Fatalf("pack mismatch:\nhave %x\nwant %x", packed[4:], want)
+	}
+}
@@ -17,15 +17,11 @@
 package abi
 
 import (
-	"crypto/rand"
 	"fmt"
-	"math/big"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/google/gofuzz"
 )
 
 // Type enumerator
@@ -42,6 +38,7 @@ const (
 	HashTy
 	FixedPointTy
 	FunctionTy
+	TupleTy
 )
 
 // Type is the reflection of the supported argument type
@@ -53,6 +50,9 @@ type Type struct {
 	Size int
 	T    byte // Our own type checking
 
+	TupleElems    []*Type  // Type information of all tuple fields
+	TupleRawNames []string // Raw field names of tuple
+
 	stringKind string // holds the unparsed string for deriving signatures
 }
 
@@ -63,6 +63,12 @@ var (
 
 // NewType creates a new reflection type of abi type given in t.
 func NewType(t string) (typ Type, err error) {
+	return newType(t, nil)
+}
+
+// newType creates a new reflection type of abi type given in t, components
+// are the fields of tuple types.
+func newType(t string, components []ArgumentMarshaling) (typ Type, err error) {
 	// check that array brackets are equal if they exist
 	if strings.Count(t, "[") != strings.Count(t, "]") {
 		return Type{}, fmt.Errorf("invalid arg type in abi")
@@ -75,12 +81,14 @@ func NewType(t string) (typ Type, err error) {
 	if strings.Count(t, "[") != 0 {
 		i := strings.LastIndex(t, "[")
 		// recursively embed the type
-		embeddedType, err := NewType(t[:i])
+		embeddedType, err := newType(t[:i], components)
 		if err != nil {
 			return Type{}, err
 		}
 		// grab the last cell and create a type from there
 		sliced := t[i:]
+		// tuples are spelled out in signatures
+		typ.stringKind = embeddedType.stringKind + sliced
 		// grab the slice size with regexp
 		re := regexp.MustCompile("[0-9]+")
 		intz := re.FindAllString(sliced, -1)
@@ -162,6 +170,37 @@ func NewType(t string) (typ Type, err error) {
 		typ.T = FunctionTy
 		typ.Size = 24
 		typ.Type = reflect.ArrayOf(24, reflect.TypeOf(byte(0)))
+	case "tuple":
+		var (
+			fields []reflect.StructField
+			elems  []*Type
+			names  []string
+			kinds  []string
+		)
+		for i, c := range components {
+			cType, err := newType(c.Type, c.Components)
+			if err != nil {
+				return Type{}, err
+			}
+			name := capitalise(c.Name)
+			if name == "" {
+				name = fmt.Sprintf("Field%d", i)
+			}
+			fields = append(fields, reflect.StructField{
+				Name: name,
+				Type: cType.Type,
+				Tag:  reflect.StructTag(fmt.Sprintf("json:%q", c.Name)),
+			})
+			elems = append(elems, &cType)
+			names = append(names, c.Name)
+			kinds = append(kinds, cType.stringKind)
+		}
+		typ.Kind = reflect.Struct
+		typ.Type = reflect.StructOf(fields)
+		typ.TupleElems = elems
+		typ.TupleRawNames = names
+		typ.T = TupleTy
+		typ.stringKind = "(" + strings.Join(kinds, ",") + ")"
 	default:
 		return Type{}, fmt.Errorf("unsupported arg type: %s", t)
 	}
@@ -174,34 +213,6 @@ func (t Type) String() (out string) {
 	return t.stringKind
 }
 
-func (t Type) fuzz(fuzzer *fuzz.Fuzzer) ([]byte, error) {
-
-	if t.T == SliceTy || t.T == ArrayTy {
-		var fuzzed []byte
-		// var slicelen int
-		// fuzzer.Fuzz(&slicelen)
-		n, _ := rand.Int(rand.Reader, big.NewInt(16))
-		slicelen := n.Int64()
-		for i := 0; i < int(slicelen); i++ {
-			val, err := t.Elem.fuzz(fuzzer)
-			// log.Printf("array[%d], %02x", i, val)
-			if err != nil {
-				return nil, err
-			}
-			fuzzed = append(fuzzed, val...)
-			// log.Printf("fuzzed after append %02x", fuzzed)
-		}
-		if t.T == SliceTy {
-			// log.Print("t.T == SliceTy")
-			return packFuzzedBytesSlice(fuzzed, fuzzer), nil
-		} else if t.T == ArrayTy {
-			// log.Print("t.T == ArrayTy")
-			return fuzzed, nil
-		}
-	}
-	return fuzzElement(t, fuzzer), nil
-}
-
 func (t Type) pack(v reflect.Value) ([]byte, error) {
 	// dereference pointer first if it's a pointer
 	v = indirect(v)
@@ -210,21 +221,32 @@ func (t Type) pack(v reflect.Value) ([]byte, error) {
 		return nil, err
 	}
 
-	if t.T == SliceTy || t.T == ArrayTy {
-		var packed []byte
+	if t.T == TupleTy {
+		fields := make([][]byte, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			val, err := elem.pack(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = val
+		}
+		return encodeSequence(t.TupleElems, fields), nil
+	}
 
-		for i := 0; i < v.Len(); i++ {
+	if t.T == SliceTy || t.T == ArrayTy {
+		elems := make([][]byte, v.Len())
+		for i := range elems {
 			val, err := t.Elem.pack(v.Index(i))
 			if err != nil {
 				return nil, err
 			}
-			packed = append(packed, val...)
+			elems[i] = val
 		}
+		packed := encodeSequence(repeatType(t.Elem, v.Len()), elems)
 		if t.T == SliceTy {
-			return packBytesSlice(packed, v.Len()), nil
-		} else if t.T == ArrayTy {
-			return packed, nil
+			return append(packNum(reflect.ValueOf(v.Len())), packed...), nil
 		}
+		return packed, nil
 	}
 	return packElement(t, v), nil
 }
@@ -234,3 +256,59 @@ func (t Type) pack(v reflect.Value) ([]byte, error) {
 func (t Type) requiresLengthPrefix() bool {
 	return t.T == StringTy || t.T == BytesTy || t.T == SliceTy
 }
+
+// isDynamic returns whether the encoding of the type is referenced by an
+// offset in the head of the enclosing tuple instead of being stored in place.
+func (t Type) isDynamic() bool {
+	switch t.T {
+	case StringTy, BytesTy, SliceTy:
+		return true
+	case ArrayTy:
+		return t.Elem.isDynamic()
+	case TupleTy:
+		for _, elem := range t.TupleElems {
+			if elem.isDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize returns the number of bytes the type takes in the head of the
+// enclosing tuple.
+func (t Type) headSize() int {
+	if t.isDynamic() {
+		return 32
+	}
+	switch t.T {
+	case ArrayTy:
+		return t.Size * t.Elem.headSize()
+	case TupleTy:
+		size := 0
+		for _, elem := range t.TupleElems {
+			size += elem.headSize()
+		}
+		return size
+	}
+	return 32
+}
+
+// encodeSequence lays out the encoded values of types as a tuple: static values
+// in place, dynamic values as offsets into the tail.
+func encodeSequence(types []*Type, values [][]byte) []byte {
+	offset := 0
+	for _, t := range types {
+		offset += t.headSize()
+	}
+	var head, tail []byte
+	for i, t := range types {
+		if t.isDynamic() {
+			head = append(head, packNum(reflect.ValueOf(offset+len(tail)))...)
+			tail = append(tail, values[i]...)
+		} else {
+			head = append(head, values[i]...)
+		}
+	}
+	return append(head, tail...)
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"minievm/common"
+	"minievm/common/hexutil"
+	"minievm/common/math"
+	"minievm/core/state"
+)
+
+//go:generate gencodec -type GenesisAccount -field-override genesisAccountMarshaling -out gen_genesis_account.go
+
+// GenesisAlloc specifies the initial state of a StateDB. It uses the same
+// JSON format as the alloc section of a geth genesis file.
+type GenesisAlloc map[common.Address]GenesisAccount
+
+// UnmarshalJSON accepts addresses with and without 0x prefix, like geth does.
+func (ga *GenesisAlloc) UnmarshalJSON(data []byte) error {
+	m := make(map[string]GenesisAccount)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*ga = make(GenesisAlloc)
+	for key, account := range m {
+		addr, err := hex.DecodeString(string(bytes.TrimPrefix([]byte(key), []byte("0x"))))
+		if err != nil || len(addr) != common.AddressLength {
+			return fmt.Errorf("invalid alloc address %q", key)
+		}
+		(*ga)[common.BytesToAddress(addr)] = account
+	}
+	return nil
+}
+
+// GenesisAccount is an account in the state of the genesis block.
+type GenesisAccount struct {
+	Code    []byte                      `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+	Balance *big.Int                    `json:"balance" gencodec:"required"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+}
+
+// field type overrides for gencodec
+type genesisAccountMarshaling struct {
+	Code    hexutil.Bytes
+	Balance *math.HexOrDecimal256
+	Nonce   math.HexOrDecimal64
+	Storage map[storageJSON]storageJSON
+}
+
+// storageJSON represents a 256 bit byte array, but allows less than 256 bits when
+// unmarshaling from hex.
+type storageJSON common.Hash
+
+func (h *storageJSON) UnmarshalText(text []byte) error {
+	text = bytes.TrimPrefix(text, []byte("0x"))
+	if len(text) > 64 {
+		return fmt.Errorf("too many hex characters in storage key/value %q", text)
+	}
+	if len(text)%2 == 1 {
+		text = append([]byte{'0'}, text...)
+	}
+	offset := len(h) - len(text)/2 // pad on the left
+	if _, err := hex.Decode(h[offset:], text); err != nil {
+		return fmt.Errorf("invalid hex storage key/value %q", text)
+	}
+	return nil
+}
+
+func (h storageJSON) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(h[:]).MarshalText()
+}
+
+// LoadAlloc reads an alloc from a JSON file. Both a bare alloc and a full
+// genesis file with an "alloc" section are accepted.
+func LoadAlloc(path string) (GenesisAlloc, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var genesis struct {
+		Alloc *GenesisAlloc `json:"alloc"`
+	}
+	if err := json.Unmarshal(data, &genesis); err == nil && genesis.Alloc != nil {
+		return *genesis.Alloc, nil
+	}
+	var alloc GenesisAlloc
+	if err := json.Unmarshal(data, &alloc); err != nil {
+		return nil, err
+	}
+	return alloc, nil
+}
+
+// SaveAlloc writes alloc to a JSON file LoadAlloc reads back.
+func SaveAlloc(path string, alloc GenesisAlloc) error {
+	data, err := json.MarshalIndent(alloc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Apply writes the alloc into statedb. Listed accounts get exactly the given
+// balance, nonce and code, listed storage slots override existing ones.
+func (ga GenesisAlloc) Apply(statedb *state.StateDB) {
+	for addr, account := range ga {
+		statedb.SetBalance(addr, account.Balance)
+		statedb.SetNonce(addr, account.Nonce)
+		if account.Code != nil {
+			statedb.SetCode(addr, account.Code)
+		}
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+}
+
+// DumpAlloc returns the live accounts of statedb as an alloc.
+func DumpAlloc(statedb *state.StateDB) GenesisAlloc {
+	alloc := make(GenesisAlloc)
+	for _, addr := range statedb.Addresses() {
+		account := GenesisAccount{
+			Code:    statedb.GetCode(addr),
+			Balance: new(big.Int).Set(statedb.GetBalance(addr)),
+			Nonce:   statedb.GetNonce(addr),
+		}
+		statedb.ForEachStorage(addr, func(key, value common.Hash) bool {
+			if account.Storage == nil {
+				account.Storage = make(map[common.Hash]common.Hash)
+			}
+			account.Storage[key] = value
+			return true
+		})
+		alloc[addr] = account
+	}
+	return alloc
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"minievm/common"
+	"minievm/core/state"
+)
+
+var testAllocJSON = `{
+	"0x00000000000000000000000000000000000000aa": {
+		"balance": "0x100",
+		"nonce": "0x2",
+		"code": "0x6001",
+		"storage": {"0x01": "0x2a"}
+	},
+	"00000000000000000000000000000000000000bb": {"balance": "1000"}
+}`
+
+func TestAllocJSON(t *testing.T) {
+	var alloc GenesisAlloc
+	if err := json.Unmarshal([]byte(testAllocJSON), &alloc); err != nil {
+		t.Fatal(err)
+	}
+	aa := alloc[common.HexToAddress("0xaa")]
+	if aa.Balance.Cmp(big.NewInt(256)) != 0 || aa.Nonce != 2 || common.Bytes2Hex(aa.Code) != "6001" {
+		t.Fatalf("account aa decoded wrong: %+v", aa)
+	}
+	if v := aa.Storage[common.BigToHash(big.NewInt(1))]; v != common.BigToHash(big.NewInt(42)) {
+		t.Fatalf("storage of aa decoded wrong: %x", v)
+	}
+	if bb := alloc[common.HexToAddress("0xbb")]; bb.Balance.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("account bb decoded wrong: %+v", bb)
+	}
+
+	data, err := json.Marshal(alloc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded GenesisAlloc
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(alloc, decoded) {
+		t.Fatalf("round trip mismatch:\nhave %+v\nwant %+v", decoded, alloc)
+	}
+
+	if err := json.Unmarshal([]byte(`{"0x12": {"balance": "1"}}`), &alloc); err == nil {
+		t.Fatal("expected error for short address")
+	}
+}
+
+func TestAllocApplyDump(t *testing.T) {
+	var alloc GenesisAlloc
+	if err := json.Unmarshal([]byte(testAllocJSON), &alloc); err != nil {
+		t.Fatal(err)
+	}
+	st := state.New()
+	addr := common.HexToAddress("0xaa")
+	st.AddBalance(addr, big.NewInt(5))
+	st.SetState(addr, common.BigToHash(big.NewInt(7)), common.BigToHash(big.NewInt(7)))
+	alloc.Apply(st)
+
+	if b := st.GetBalance(addr); b.Cmp(big.NewInt(256)) != 0 {
+		t.Fatalf("balance not overridden: %v", b)
+	}
+	dump := DumpAlloc(st)
+	if len(dump) != 2 {
+		t.Fatalf("dump has %d accounts, want 2", len(dump))
+	}
+	if len(dump[addr].Storage) != 2 {
+		t.Fatalf("existing storage should be kept, have %v", dump[addr].Storage)
+	}
+	if !reflect.DeepEqual(dump[common.HexToAddress("0xbb")], alloc[common.HexToAddress("0xbb")]) {
+		t.Fatalf("account bb mismatch: %+v", dump[common.HexToAddress("0xbb")])
+	}
+}
+
+func TestLoadAlloc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alloc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bare := filepath.Join(dir, "alloc.json")
+	full := filepath.Join(dir, "genesis.json")
+	ioutil.WriteFile(bare, []byte(testAllocJSON), 0644)
+	ioutil.WriteFile(full, []byte(`{"config": {}, "alloc": `+testAllocJSON+`}`), 0644)
+	for _, file := range []string{bare, full} {
+		alloc, err := LoadAlloc(file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if len(alloc) != 2 {
+			t.Fatalf("%s: loaded %d accounts, want 2", file, len(alloc))
+		}
+	}
+
+	alloc, _ := LoadAlloc(bare)
+	saved := filepath.Join(dir, "saved.json")
+	if err := SaveAlloc(saved, alloc); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadAlloc(saved); err != nil || !reflect.DeepEqual(loaded, alloc) {
+		t.Fatalf("saved alloc mismatch: have %+v, want %+v (err %v)", loaded, alloc, err)
+	}
+}
@@ -0,0 +1,196 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"minievm/common"
+	"minievm/core/types"
+	"minievm/crypto"
+	"minievm/ethdb"
+	"minievm/rlp"
+	"minievm/trie"
+	"sort"
+)
+
+// checkpointPrefix + id -> rlp(checkpoint)
+var checkpointPrefix = []byte("minievm-checkpoint-")
+
+func checkpointKey(id common.Hash) []byte {
+	return append(append([]byte{}, checkpointPrefix...), id[:]...)
+}
+
+// checkpoint is what a saved state is loaded back from. The logs aren't part
+// of the account root, so states with the same accounts but different logs
+// share the root and get different checkpoints. Neither are deleted accounts
+// and zero slots, the trie can't hold them, but without them a Backend would
+// read them through again after loading.
+type checkpoint struct {
+	Root    common.Hash
+	Logs    []*types.LogForStorage
+	Deleted []common.Address
+	Zeroed  []zeroedSlot
+}
+
+// zeroedSlot is a storage slot set to zero.
+type zeroedSlot struct {
+	Account common.Address
+	Key     common.Hash
+}
+
+// Save writes the accounts, code, storage and logs of the state into db and
+// returns the id it can be loaded back from with Load, the hash of the root
+// and the logs. Accounts are stored as tries, so states sharing content share
+// database entries too. Suicided accounts are saved as deleted, empty ones
+// are kept.
+func (st *StateDB) Save(db ethdb.Database) (common.Hash, error) {
+	tdb := trie.NewDatabase(db)
+	tr := newTrie(tdb)
+	cp := checkpoint{Deleted: st.deleted()}
+	for addr, s := range st.accounts() {
+		if s.isSuicide {
+			cp.Deleted = append(cp.Deleted, addr)
+			continue
+		}
+		for key, val := range s.allStorage() {
+			if val == (common.Hash{}) {
+				cp.Zeroed = append(cp.Zeroed, zeroedSlot{addr, key})
+			}
+		}
+		root, err := s.storageTrie(tdb).Commit(nil)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if len(s.code) > 0 {
+			if err := db.Put(s.codeHash[:], s.code); err != nil {
+				return common.Hash{}, err
+			}
+		}
+		data, err := rlp.EncodeToBytes(s.account(root))
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("can't encode object at %x: %v", addr[:], err)
+		}
+		tr.Update(addr[:], data)
+	}
+	// Reference the storage tries from the account leaves, so committing
+	// the account trie flushes them as well.
+	root, err := tr.Commit(func(leaf []byte, parent common.Hash) error {
+		var account Account
+		if err := rlp.DecodeBytes(leaf, &account); err != nil {
+			return nil
+		}
+		if account.Root != emptyRoot {
+			tdb.Reference(account.Root, parent)
+		}
+		return nil
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := tdb.Commit(root, false); err != nil {
+		return common.Hash{}, err
+	}
+
+	// Sort the lists so equal states get equal ids.
+	sort.Slice(cp.Deleted, func(i, j int) bool { return bytes.Compare(cp.Deleted[i][:], cp.Deleted[j][:]) < 0 })
+	sort.Slice(cp.Zeroed, func(i, j int) bool {
+		if c := bytes.Compare(cp.Zeroed[i].Account[:], cp.Zeroed[j].Account[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(cp.Zeroed[i].Key[:], cp.Zeroed[j].Key[:]) < 0
+	})
+	cp.Root = root
+	cp.Logs = make([]*types.LogForStorage, len(st.Logs))
+	for i, log := range st.Logs {
+		cp.Logs[i] = (*types.LogForStorage)(log)
+	}
+	data, err := rlp.EncodeToBytes(cp)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	id := crypto.Keccak256Hash(data)
+	return id, db.Put(checkpointKey(id), data)
+}
+
+// Load reads the state saved under id by Save from db.
+func Load(id common.Hash, db ethdb.Database) (*StateDB, error) {
+	return LoadWithConfig(id, db, Config{})
+}
+
+// LoadWithConfig reads the state saved under id by Save from db, using the
+// given options. With a Backend, accounts and slots the saved state doesn't
+// hold are fetched through it like those of the accounts fetched later, the
+// deleted accounts and zeroed slots it held are not.
+func LoadWithConfig(id common.Hash, db ethdb.Database, cfg Config) (*StateDB, error) {
+	data, err := db.Get(checkpointKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("missing checkpoint %x: %v", id[:], err)
+	}
+	var cp checkpoint
+	if err := rlp.DecodeBytes(data, &cp); err != nil {
+		return nil, err
+	}
+	tdb := trie.NewDatabase(db)
+	tr, err := trie.NewSecure(cp.Root, tdb, 0)
+	if err != nil {
+		return nil, err
+	}
+	st := NewWithConfig(cfg)
+
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		addr := common.BytesToAddress(tr.GetKey(it.Key))
+		var account Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return nil, fmt.Errorf("can't decode object at %x: %v", addr[:], err)
+		}
+		s := newState(addr)
+		s.nonce = account.Nonce
+		s.balance = account.Balance
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCodeHash {
+			if s.code, err = db.Get(codeHash[:]); err != nil {
+				return nil, fmt.Errorf("missing code of %x: %v", addr[:], err)
+			}
+			s.codeHash = codeHash
+		}
+		if err := loadStorage(s, account.Root, tdb); err != nil {
+			return nil, err
+		}
+		if cfg.Backend != nil {
+			s.origin = st
+		}
+		st.StateMap[addr] = s
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	for _, addr := range cp.Deleted {
+		st.StateMap[addr] = nil
+	}
+	for _, slot := range cp.Zeroed {
+		if s := st.StateMap[slot.Account]; s != nil {
+			s.storage[slot.Key] = common.Hash{}
+		}
+	}
+
+	for _, log := range cp.Logs {
+		st.Logs = append(st.Logs, (*types.Log)(log))
+	}
+	return st, nil
+}
+
+// loadStorage fills the storage of s from the storage trie at root.
+func loadStorage(s *State, root common.Hash, tdb *trie.Database) error {
+	tr, err := trie.NewSecure(root, tdb, 0)
+	if err != nil {
+		return err
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var val []byte
+		if err := rlp.DecodeBytes(it.Value, &val); err != nil {
+			return fmt.Errorf("can't decode storage of %x: %v", s.address[:], err)
+		}
+		s.storage[common.BytesToHash(tr.GetKey(it.Key))] = common.BytesToHash(val)
+	}
+	return it.Err
+}
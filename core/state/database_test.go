@@ -0,0 +1,172 @@
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"minievm/common"
+	"minievm/core/types"
+	"minievm/ethdb"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	st := New()
+	contract := common.BytesToAddress([]byte("contract"))
+	user := common.BytesToAddress([]byte("user"))
+	st.AddBalance(user, big.NewInt(100))
+	st.SetNonce(user, 20)
+	st.SetCode(contract, []byte{0x60, 0x00, 0x60, 0x00, 0xf3})
+	st.SetState(contract, common.BytesToHash([]byte{1}), common.BytesToHash([]byte{0xaa}))
+	st.SetState(contract, common.BytesToHash([]byte{2}), common.Hash{})
+	st.AddLog(&types.Log{Address: contract, Topics: []common.Hash{common.BytesToHash([]byte("topic"))}, Data: []byte{1, 2}})
+
+	gone := common.BytesToAddress([]byte("gone"))
+	st.AddBalance(gone, big.NewInt(1))
+	st.Suicide(gone)
+
+	db, _ := ethdb.NewMemDatabase()
+	fork := st.Fork()
+	fork.SetState(contract, common.BytesToHash([]byte{3}), common.BytesToHash([]byte{0xbb}))
+	id, err := fork.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fork.IntermediateRoot(false)
+
+	loaded, err := Load(id, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := loaded.IntermediateRoot(false); have != root {
+		t.Errorf("loaded root mismatch: have %x, want %x", have, root)
+	}
+	if loaded.GetNonce(user) != 20 || loaded.GetBalance(user).Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("user account mismatch: %s", loaded.StateMap[user])
+	}
+	if !bytes.Equal(loaded.GetCode(contract), st.GetCode(contract)) || loaded.GetCodeHash(contract) != st.GetCodeHash(contract) {
+		t.Errorf("code mismatch: have %x", loaded.GetCode(contract))
+	}
+	if val := loaded.GetState(contract, common.BytesToHash([]byte{3})); val != common.BytesToHash([]byte{0xbb}) {
+		t.Errorf("storage mismatch: have %x", val)
+	}
+	if loaded.Exist(gone) {
+		t.Errorf("suicided account was saved")
+	}
+	if len(loaded.Logs) != 1 || !bytes.Equal(loaded.Logs[0].Data, []byte{1, 2}) || loaded.Logs[0].Address != contract {
+		t.Errorf("logs mismatch: %v", loaded.Logs)
+	}
+
+	// The unforked state is still loadable from the same database.
+	id2, err := st.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = Load(id2, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val := loaded.GetState(contract, common.BytesToHash([]byte{3})); val != (common.Hash{}) {
+		t.Errorf("second checkpoint sees fork storage: have %x", val)
+	}
+	if _, err := Load(common.BytesToHash([]byte("missing")), db); err == nil {
+		t.Errorf("loading an unknown id should fail")
+	}
+
+	// Same accounts, different logs: the root is shared, the checkpoint isn't.
+	fork.AddLog(&types.Log{Address: user})
+	id3, err := fork.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id3 == id || fork.IntermediateRoot(false) != root {
+		t.Fatalf("checkpoints of equal accounts with different logs collide")
+	}
+	if loaded, _ := Load(id, db); len(loaded.Logs) != 1 {
+		t.Errorf("first checkpoint logs overwritten: have %d, want 1", len(loaded.Logs))
+	}
+}
+
+// mapBackend serves the accounts and slots in its maps and counts the
+// storage requests.
+type mapBackend struct {
+	accounts map[common.Address]*RemoteAccount
+	storage  map[common.Hash]common.Hash
+	requests int
+}
+
+func (b *mapBackend) Account(addr common.Address) (*RemoteAccount, error) {
+	return b.accounts[addr], nil
+}
+
+func (b *mapBackend) Storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	b.requests++
+	return b.storage[key], nil
+}
+
+func TestLoadWithBackend(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	saved, remote := common.BytesToHash([]byte{1}), common.BytesToHash([]byte{2})
+	backend := &mapBackend{storage: map[common.Hash]common.Hash{remote: common.BytesToHash([]byte{0xbb})}}
+
+	st := New()
+	st.SetCode(contract, []byte{0x00})
+	st.SetState(contract, saved, common.BytesToHash([]byte{0xaa}))
+	db, _ := ethdb.NewMemDatabase()
+	id, err := st.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadWithConfig(id, db, Config{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val := loaded.GetState(contract, saved); val != common.BytesToHash([]byte{0xaa}) || backend.requests != 0 {
+		t.Errorf("saved slot mismatch: have %x after %d requests", val, backend.requests)
+	}
+	if val := loaded.Fork().GetState(contract, remote); val != common.BytesToHash([]byte{0xbb}) {
+		t.Errorf("slot missing from the checkpoint not fetched: have %x", val)
+	}
+}
+
+func TestLoadWithBackendDeleted(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	gone := common.BytesToAddress([]byte("gone"))
+	dying := common.BytesToAddress([]byte("dying"))
+	slot := common.BytesToHash([]byte{1})
+	backend := &mapBackend{
+		accounts: map[common.Address]*RemoteAccount{
+			contract: {Balance: big.NewInt(1), Code: []byte{0x00}},
+			gone:     {Balance: big.NewInt(5)},
+			dying:    {Balance: big.NewInt(5)},
+		},
+		storage: map[common.Hash]common.Hash{slot: common.BytesToHash([]byte{0x2a})},
+	}
+
+	st := NewWithConfig(Config{Backend: backend})
+	if val := st.GetState(contract, slot); val != common.BytesToHash([]byte{0x2a}) {
+		t.Fatalf("remote slot mismatch: have %x", val)
+	}
+	st.SetState(contract, slot, common.Hash{})
+	st.Suicide(gone)
+	st.Finalise(true)
+	st.Suicide(dying)
+	db, _ := ethdb.NewMemDatabase()
+	id, err := st.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadWithConfig(id, db, Config{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val := loaded.GetState(contract, slot); val != (common.Hash{}) {
+		t.Errorf("zeroed slot read through the backend: have %x", val)
+	}
+	for _, addr := range []common.Address{gone, dying} {
+		if loaded.Exist(addr) || loaded.GetBalance(addr).Sign() != 0 {
+			t.Errorf("deleted account %x read through the backend", addr[:])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package state
+
+import (
+	"math/big"
+	"minievm/common"
+)
+
+// journalEntry is a modification entry in the state change journal that can be
+// reverted on demand.
+type journalEntry interface {
+	undo(*StateDB)
+}
+
+// journal contains the list of state modifications applied since the last
+// Finalise, in the order they happened.
+type journal []journalEntry
+
+// dirties returns the accounts modified by the journaled changes.
+func (j journal) dirties() map[common.Address]struct{} {
+	dirty := make(map[common.Address]struct{})
+	for _, entry := range j {
+		switch ch := entry.(type) {
+		case createObjectChange:
+			dirty[ch.account] = struct{}{}
+		case resetObjectChange:
+			dirty[ch.account] = struct{}{}
+		case suicideChange:
+			dirty[ch.account] = struct{}{}
+		case balanceChange:
+			dirty[ch.account] = struct{}{}
+		case nonceChange:
+			dirty[ch.account] = struct{}{}
+		case storageChange:
+			dirty[ch.account] = struct{}{}
+		case codeChange:
+			dirty[ch.account] = struct{}{}
+		}
+	}
+	return dirty
+}
+
+// revision marks a point in the journal that a snapshot id refers to.
+type revision struct {
+	id           int
+	journalIndex int
+}
+
+type (
+	// Changes to the account map.
+	createObjectChange struct {
+		account common.Address
+	}
+	resetObjectChange struct {
+		account common.Address
+		prev    *State // nil if the account had been deleted
+	}
+	suicideChange struct {
+		account     common.Address
+		prev        bool // whether account had already suicided
+		prevbalance *big.Int
+	}
+
+	// Changes to individual accounts.
+	balanceChange struct {
+		account common.Address
+		prev    *big.Int
+	}
+	nonceChange struct {
+		account common.Address
+		prev    uint64
+	}
+	storageChange struct {
+		account       common.Address
+		key, prevalue common.Hash
+		existed       bool
+	}
+	codeChange struct {
+		account  common.Address
+		prevcode []byte
+		prevhash common.Hash
+	}
+
+	// Changes to other state values.
+	refundChange struct {
+		prev uint64
+	}
+	addLogChange      struct{}
+	addPreimageChange struct {
+		hash common.Hash
+	}
+)
+
+func (ch createObjectChange) undo(st *StateDB) {
+	delete(st.StateMap, ch.account)
+}
+
+func (ch resetObjectChange) undo(st *StateDB) {
+	st.StateMap[ch.account] = ch.prev
+}
+
+func (ch suicideChange) undo(st *StateDB) {
+	if s, ok := st.StateMap[ch.account]; ok {
+		s.isSuicide = ch.prev
+		s.balance = ch.prevbalance
+	}
+}
+
+func (ch balanceChange) undo(st *StateDB) {
+	st.StateMap[ch.account].balance = ch.prev
+}
+
+func (ch nonceChange) undo(st *StateDB) {
+	st.StateMap[ch.account].nonce = ch.prev
+}
+
+func (ch storageChange) undo(st *StateDB) {
+	storage := st.StateMap[ch.account].storage
+	if ch.existed {
+		storage[ch.key] = ch.prevalue
+	} else {
+		delete(storage, ch.key)
+	}
+}
+
+func (ch codeChange) undo(st *StateDB) {
+	s := st.StateMap[ch.account]
+	s.code = ch.prevcode
+	s.codeHash = ch.prevhash
+}
+
+func (ch refundChange) undo(st *StateDB) {
+	st.refund = ch.prev
+}
+
+func (ch addLogChange) undo(st *StateDB) {
+	st.Logs = st.Logs[:len(st.Logs)-1]
+}
+
+func (ch addPreimageChange) undo(st *StateDB) {
+	delete(st.preimages, ch.hash)
+}
@@ -0,0 +1,144 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"minievm/common"
+	"minievm/common/hexutil"
+	"minievm/ethdb"
+	"minievm/rlp"
+)
+
+// Backend supplies accounts and storage slots that have never been seen by a
+// StateDB, e.g. the state of a real chain at some block.
+type Backend interface {
+	// Account returns the account at addr, or nil if it does not exist.
+	Account(addr common.Address) (*RemoteAccount, error)
+	// Storage returns the value of the storage slot key of addr.
+	Storage(addr common.Address, key common.Hash) (common.Hash, error)
+}
+
+// RemoteAccount is an account as returned by a Backend.
+type RemoteAccount struct {
+	Balance *big.Int
+	Nonce   uint64
+	Code    []byte
+}
+
+// Caller performs JSON-RPC calls. It is satisfied by *rpc.Client.
+type Caller interface {
+	Call(result interface{}, method string, args ...interface{}) error
+}
+
+// remotePrefix + block + address (+ slot) -> cached account or slot value
+var remotePrefix = []byte("minievm-remote-")
+
+// RPCBackend fetches state lazily from a JSON-RPC endpoint at a pinned block.
+// Everything fetched is cached in a database, so running against the same
+// block again does not hit the endpoint.
+type RPCBackend struct {
+	client Caller
+	block  uint64
+	cache  ethdb.Database
+}
+
+// NewRPCBackend creates a backend reading the state at block through client.
+// cache may be nil, in which case nothing is cached.
+func NewRPCBackend(client Caller, block uint64, cache ethdb.Database) *RPCBackend {
+	return &RPCBackend{client: client, block: block, cache: cache}
+}
+
+func (b *RPCBackend) cacheKey(addr common.Address, key []byte) []byte {
+	k := append([]byte{}, remotePrefix...)
+	k = append(k, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(k[len(remotePrefix):], b.block)
+	k = append(k, addr[:]...)
+	return append(k, key...)
+}
+
+func (b *RPCBackend) blockArg() string {
+	return hexutil.EncodeUint64(b.block)
+}
+
+// Account implements Backend.
+func (b *RPCBackend) Account(addr common.Address) (*RemoteAccount, error) {
+	key := b.cacheKey(addr, nil)
+	if b.cache != nil {
+		if data, err := b.cache.Get(key); err == nil {
+			return decodeRemoteAccount(data)
+		}
+	}
+	var (
+		balance hexutil.Big
+		nonce   hexutil.Uint64
+		code    hexutil.Bytes
+	)
+	if err := b.client.Call(&balance, "eth_getBalance", addr, b.blockArg()); err != nil {
+		return nil, fmt.Errorf("can't fetch balance of %x: %v", addr[:], err)
+	}
+	if err := b.client.Call(&nonce, "eth_getTransactionCount", addr, b.blockArg()); err != nil {
+		return nil, fmt.Errorf("can't fetch nonce of %x: %v", addr[:], err)
+	}
+	if err := b.client.Call(&code, "eth_getCode", addr, b.blockArg()); err != nil {
+		return nil, fmt.Errorf("can't fetch code of %x: %v", addr[:], err)
+	}
+	account := &RemoteAccount{Balance: balance.ToInt(), Nonce: uint64(nonce), Code: code}
+	if account.Balance.Sign() == 0 && account.Nonce == 0 && len(account.Code) == 0 {
+		account = nil
+	}
+	if b.cache != nil {
+		data, err := encodeRemoteAccount(account)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.cache.Put(key, data); err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+// Storage implements Backend.
+func (b *RPCBackend) Storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	ck := b.cacheKey(addr, key[:])
+	if b.cache != nil {
+		if data, err := b.cache.Get(ck); err == nil {
+			return common.BytesToHash(data), nil
+		}
+	}
+	var val hexutil.Bytes
+	if err := b.client.Call(&val, "eth_getStorageAt", addr, key, b.blockArg()); err != nil {
+		return common.Hash{}, fmt.Errorf("can't fetch storage %x of %x: %v", key[:], addr[:], err)
+	}
+	if len(val) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("storage %x of %x too long: %d bytes", key[:], addr[:], len(val))
+	}
+	value := common.BytesToHash(val)
+	if b.cache != nil {
+		if err := b.cache.Put(ck, value[:]); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return value, nil
+}
+
+// encodeRemoteAccount encodes account for the cache, nil is stored as an
+// empty list so missing accounts are cached as well.
+func encodeRemoteAccount(account *RemoteAccount) ([]byte, error) {
+	if account == nil {
+		return rlp.EncodeToBytes([]interface{}{})
+	}
+	return rlp.EncodeToBytes(account)
+}
+
+func decodeRemoteAccount(data []byte) (*RemoteAccount, error) {
+	if content, _, err := rlp.SplitList(data); err == nil && len(content) == 0 {
+		return nil, nil
+	}
+	account := new(RemoteAccount)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
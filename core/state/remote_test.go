@@ -0,0 +1,190 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"minievm/common"
+	"minievm/common/hexutil"
+	"minievm/ethdb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubNode is a JSON-RPC server serving a fixed state at a single block.
+type stubNode struct {
+	block    uint64
+	accounts map[common.Address]*RemoteAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+	calls    map[string]int
+}
+
+type stubRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (n *stubNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req stubRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.calls[req.Method]++
+	var (
+		addr  common.Address
+		key   common.Hash
+		block hexutil.Uint64
+	)
+	json.Unmarshal(req.Params[0], &addr)
+	json.Unmarshal(req.Params[len(req.Params)-1], &block)
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if uint64(block) != n.block {
+		resp["error"] = map[string]interface{}{"code": -32000, "message": "unknown block"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	account := n.accounts[addr]
+	if account == nil {
+		account = &RemoteAccount{Balance: new(big.Int)}
+	}
+	switch req.Method {
+	case "eth_getBalance":
+		resp["result"] = (*hexutil.Big)(account.Balance)
+	case "eth_getTransactionCount":
+		resp["result"] = hexutil.Uint64(account.Nonce)
+	case "eth_getCode":
+		resp["result"] = hexutil.Bytes(account.Code)
+	case "eth_getStorageAt":
+		json.Unmarshal(req.Params[1], &key)
+		resp["result"] = n.storage[addr][key]
+	default:
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// httpCaller is a minimal JSON-RPC over HTTP client.
+type httpCaller struct {
+	url string
+}
+
+func (c *httpCaller) Call(result interface{}, method string, args ...interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": args})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var msg struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return err
+	}
+	if msg.Error != nil {
+		return fmt.Errorf("%s", msg.Error.Message)
+	}
+	return json.Unmarshal(msg.Result, result)
+}
+
+func newStubNode() *stubNode {
+	token := common.HexToAddress("0x10")
+	return &stubNode{
+		block: 100,
+		accounts: map[common.Address]*RemoteAccount{
+			token:                       {Balance: big.NewInt(0), Nonce: 1, Code: []byte{0x60, 0x01}},
+			common.HexToAddress("0x20"): {Balance: big.NewInt(500), Nonce: 3},
+		},
+		storage: map[common.Address]map[common.Hash]common.Hash{
+			token: {common.BigToHash(big.NewInt(0)): common.BigToHash(big.NewInt(1000))},
+		},
+		calls: map[string]int{},
+	}
+}
+
+func TestRemoteBackend(t *testing.T) {
+	node := newStubNode()
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	cache, _ := ethdb.NewMemDatabase()
+	st := NewWithConfig(Config{Backend: NewRPCBackend(&httpCaller{server.URL}, 100, cache)})
+	token, user := common.HexToAddress("0x10"), common.HexToAddress("0x20")
+	slot := common.BigToHash(big.NewInt(0))
+
+	if b := st.GetBalance(user); b.Cmp(big.NewInt(500)) != 0 {
+		t.Fatalf("balance mismatch: %v", b)
+	}
+	if n := st.GetNonce(user); n != 3 {
+		t.Fatalf("nonce mismatch: %d", n)
+	}
+	if !bytes.Equal(st.GetCode(token), []byte{0x60, 0x01}) {
+		t.Fatalf("code mismatch: %x", st.GetCode(token))
+	}
+	if v := st.GetState(token, slot); v != common.BigToHash(big.NewInt(1000)) {
+		t.Fatalf("storage mismatch: %x", v)
+	}
+	if st.Exist(common.HexToAddress("0x30")) {
+		t.Fatal("unknown account should not exist")
+	}
+
+	// Writes stay local and can be reverted to the fetched values.
+	fork := st.Fork()
+	id := fork.Snapshot()
+	fork.SetState(token, slot, common.Hash{})
+	fork.SubBalance(user, big.NewInt(100))
+	fork.RevertToSnapshot(id)
+	if v := fork.GetState(token, slot); v != common.BigToHash(big.NewInt(1000)) {
+		t.Fatalf("storage after revert: %x", v)
+	}
+	if b := fork.GetBalance(user); b.Cmp(big.NewInt(500)) != 0 {
+		t.Fatalf("balance after revert: %v", b)
+	}
+	if err := st.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything was fetched once, a fresh state is served from the cache.
+	for method, n := range node.calls {
+		if n > 3 {
+			t.Errorf("%s called %d times", method, n)
+		}
+	}
+	server.Close()
+	cached := NewWithConfig(Config{Backend: NewRPCBackend(&httpCaller{server.URL}, 100, cache)})
+	if v := cached.GetState(token, slot); v != common.BigToHash(big.NewInt(1000)) {
+		t.Fatalf("cached storage mismatch: %x", v)
+	}
+	if cached.Exist(common.HexToAddress("0x30")) {
+		t.Fatal("cached unknown account should not exist")
+	}
+	if err := cached.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoteBackendError(t *testing.T) {
+	server := httptest.NewServer(newStubNode())
+	defer server.Close()
+
+	st := NewWithConfig(Config{Backend: NewRPCBackend(&httpCaller{server.URL}, 99, nil)})
+	if st.Exist(common.HexToAddress("0x20")) {
+		t.Fatal("account should not load at unknown block")
+	}
+	if st.Error() == nil {
+		t.Fatal("expected backend error")
+	}
+	if len(st.missing) != 0 {
+		t.Errorf("failed fetch cached as nonexistent")
+	}
+}
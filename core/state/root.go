@@ -0,0 +1,168 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"minievm/common"
+	"minievm/crypto"
+	"minievm/ethdb"
+	"minievm/rlp"
+	"minievm/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCodeHash is the known hash of empty code.
+	emptyCodeHash = crypto.Keccak256Hash(nil)
+)
+
+// Account is the Ethereum consensus representation of accounts.
+// These objects are stored in the main account trie.
+type Account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash // merkle root of the storage trie
+	CodeHash []byte
+}
+
+// proofList collects the trie nodes written by Prove, root first.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+// newTrie creates an empty trie on top of db, or on a throwaway in-memory
+// database if db is nil.
+func newTrie(db *trie.Database) *trie.SecureTrie {
+	if db == nil {
+		memdb, _ := ethdb.NewMemDatabase()
+		db = trie.NewDatabase(memdb)
+	}
+	tr, err := trie.NewSecure(common.Hash{}, db, 0)
+	if err != nil {
+		panic(err)
+	}
+	return tr
+}
+
+// storageTrie builds the storage trie of the account on top of db.
+func (s *State) storageTrie(db *trie.Database) *trie.SecureTrie {
+	tr := newTrie(db)
+	for key, val := range s.allStorage() {
+		if val == (common.Hash{}) {
+			continue
+		}
+		// Encoding []byte cannot fail, ok to ignore the error.
+		v, _ := rlp.EncodeToBytes(bytes.TrimLeft(val[:], "\x00"))
+		tr.Update(key[:], v)
+	}
+	return tr
+}
+
+// account returns the consensus representation of the account, given the
+// root of its storage trie.
+func (s *State) account(root common.Hash) Account {
+	codeHash := s.codeHash
+	if codeHash == (common.Hash{}) {
+		codeHash = emptyCodeHash
+	}
+	return Account{
+		Nonce:    s.nonce,
+		Balance:  s.balance,
+		Root:     root,
+		CodeHash: codeHash[:],
+	}
+}
+
+// accounts returns all accounts as seen by this layer.
+func (st *StateDB) accounts() map[common.Address]*State {
+	accounts := make(map[common.Address]*State)
+	for ; st != nil; st = st.parent {
+		for addr, s := range st.StateMap {
+			if _, ok := accounts[addr]; !ok {
+				accounts[addr] = s
+			}
+		}
+	}
+	for addr, s := range accounts {
+		if s == nil {
+			delete(accounts, addr)
+		}
+	}
+	return accounts
+}
+
+// deleted returns the accounts this layer sees as deleted.
+func (st *StateDB) deleted() []common.Address {
+	var deleted []common.Address
+	seen := make(map[common.Address]struct{})
+	for ; st != nil; st = st.parent {
+		for addr, s := range st.StateMap {
+			if _, ok := seen[addr]; !ok {
+				seen[addr] = struct{}{}
+				if s == nil {
+					deleted = append(deleted, addr)
+				}
+			}
+		}
+	}
+	return deleted
+}
+
+// accountTrie builds the account trie. Suicided accounts are left out, as are
+// empty ones if deleteEmptyObjects is set (EIP158).
+func (st *StateDB) accountTrie(deleteEmptyObjects bool) *trie.SecureTrie {
+	tr := newTrie(nil)
+	for addr, s := range st.accounts() {
+		if s.isSuicide || (deleteEmptyObjects && s.empty()) {
+			continue
+		}
+		data, err := rlp.EncodeToBytes(s.account(s.storageTrie(nil).Hash()))
+		if err != nil {
+			panic(fmt.Errorf("can't encode object at %x: %v", addr[:], err))
+		}
+		tr.Update(addr[:], data)
+	}
+	return tr
+}
+
+// IntermediateRoot computes the current root hash of the state trie.
+// It is called in between transactions to get the root hash that
+// goes into transaction receipts.
+func (st *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
+	return st.accountTrie(deleteEmptyObjects).Hash()
+}
+
+// StorageRoot returns the root hash of the storage trie of addr.
+func (st *StateDB) StorageRoot(addr common.Address) common.Hash {
+	s := st.getStateObject(addr)
+	if s == nil {
+		return emptyRoot
+	}
+	return s.storageTrie(nil).Hash()
+}
+
+// GetProof returns the merkle proof of the account at addr against
+// IntermediateRoot(true). The proof nodes are keyed by the hashed address.
+func (st *StateDB) GetProof(addr common.Address) ([][]byte, error) {
+	var proof proofList
+	err := st.accountTrie(true).Prove(crypto.Keccak256(addr[:]), 0, &proof)
+	return [][]byte(proof), err
+}
+
+// GetStorageProof returns the merkle proof of the storage slot key against
+// StorageRoot(addr). The proof nodes are keyed by the hashed slot.
+func (st *StateDB) GetStorageProof(addr common.Address, key common.Hash) ([][]byte, error) {
+	var proof proofList
+	tr := newTrie(nil)
+	if s := st.getStateObject(addr); s != nil {
+		tr = s.storageTrie(nil)
+	}
+	err := tr.Prove(crypto.Keccak256(key[:]), 0, &proof)
+	return [][]byte(proof), err
+}
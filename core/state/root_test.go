@@ -0,0 +1,99 @@
+package state
+
+import (
+	"math/big"
+	"minievm/common"
+	"minievm/crypto"
+	"minievm/ethdb"
+	"minievm/rlp"
+	"minievm/trie"
+	"testing"
+)
+
+func proofDB(t *testing.T, proof [][]byte) *ethdb.MemDatabase {
+	db, _ := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		if err := db.Put(crypto.Keccak256(node), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestIntermediateRoot(t *testing.T) {
+	st := New()
+	if root := st.IntermediateRoot(true); root != emptyRoot {
+		t.Fatalf("empty state root mismatch: have %x, want %x", root, emptyRoot)
+	}
+
+	addr := common.BytesToAddress([]byte("root"))
+	st.CreateAccount(addr)
+	if root := st.IntermediateRoot(true); root != emptyRoot {
+		t.Errorf("empty account should be left out post EIP158: have %x", root)
+	}
+	if root := st.IntermediateRoot(false); root == emptyRoot {
+		t.Errorf("empty account should be kept pre EIP158")
+	}
+
+	st.AddBalance(addr, big.NewInt(1))
+	root := st.IntermediateRoot(true)
+
+	snap := st.Snapshot()
+	st.SetState(addr, common.BytesToHash([]byte{1}), common.BytesToHash([]byte{1}))
+	if st.IntermediateRoot(true) == root {
+		t.Errorf("storage write didn't change the root")
+	}
+	st.RevertToSnapshot(snap)
+	if have := st.IntermediateRoot(true); have != root {
+		t.Errorf("root after revert: have %x, want %x", have, root)
+	}
+
+	// Equal content must hash equally, no matter how it is layered.
+	fork := st.Fork()
+	other := st.Fork()
+	fork.SetState(addr, common.BytesToHash([]byte{2}), common.BytesToHash([]byte{2}))
+	other.SetState(addr, common.BytesToHash([]byte{2}), common.BytesToHash([]byte{3}))
+	other.SetState(addr, common.BytesToHash([]byte{2}), common.BytesToHash([]byte{2}))
+	if fork.IntermediateRoot(true) != other.IntermediateRoot(true) {
+		t.Errorf("equal forks hash differently")
+	}
+}
+
+func TestGetProof(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("proof"))
+	key := common.BytesToHash([]byte{1})
+	st.AddBalance(addr, big.NewInt(42))
+	st.SetNonce(addr, 3)
+	st.SetState(addr, key, common.BytesToHash([]byte{0xff}))
+	st.AddBalance(common.BytesToAddress([]byte("other")), big.NewInt(1))
+
+	proof, err := st.GetProof(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err, _ := trie.VerifyProof(st.IntermediateRoot(true), crypto.Keccak256(addr[:]), proofDB(t, proof))
+	if err != nil {
+		t.Fatalf("account proof doesn't verify: %v", err)
+	}
+	var account Account
+	if err := rlp.DecodeBytes(enc, &account); err != nil {
+		t.Fatal(err)
+	}
+	if account.Nonce != 3 || account.Balance.Cmp(big.NewInt(42)) != 0 || account.Root != st.StorageRoot(addr) {
+		t.Errorf("proven account mismatch: %+v", account)
+	}
+
+	proof, err = st.GetStorageProof(addr, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err, _ = trie.VerifyProof(account.Root, crypto.Keccak256(key[:]), proofDB(t, proof))
+	if err != nil {
+		t.Fatalf("storage proof doesn't verify: %v", err)
+	}
+	var val []byte
+	if err := rlp.DecodeBytes(enc, &val); err != nil || len(val) != 1 || val[0] != 0xff {
+		t.Errorf("proven slot mismatch: have %x, %v", val, err)
+	}
+}
@@ -1,16 +1,44 @@
 package state
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 	"minievm/common"
 	"minievm/core/types"
+	"minievm/crypto"
+	"sort"
 	"strings"
 )
 
 type StateDB struct {
 	StateMap map[common.Address]*State
 	Logs     []*types.Log
+
+	config Config
+
+	// parent is the state this one was forked from. Accounts missing from
+	// StateMap are looked up in the parent and copied on first write. An
+	// account deleted by Finalise is kept as a nil entry, it reads as empty
+	// instead of through the parent, the Backend or AutoFund.
+	parent *StateDB
+
+	refund uint64
+
+	// preimages maps keccak hashes seen by SHA3 back to their input.
+	preimages map[common.Hash][]byte
+
+	// missing holds the accounts config.Backend reported as nonexistent,
+	// dbErr the first error it returned or the first balance underflow. Both
+	// are only used in the root layer.
+	missing map[common.Address]struct{}
+	dbErr   error
+
+	// Journal of state modifications. This is the backbone of
+	// Snapshot and RevertToSnapshot.
+	journal        journal
+	validRevisions []revision
+	nextRevisionId int
 }
 
 type State struct {
@@ -21,10 +49,85 @@ type State struct {
 	code      []byte
 	isSuicide bool
 	storage   map[common.Hash]common.Hash
+
+	// parent is the same account in the forked-from state, storage slots
+	// not written in this layer are read through it.
+	parent *State
+
+	// origin is the state the account was fetched into from a Backend.
+	// Slots missing from the bottom layer are fetched through it.
+	origin *StateDB
+}
+
+// Config are the configuration options for the StateDB
+type Config struct {
+	// AutoFund, if set, is the balance every unknown account starts with.
+	// Unknown accounts report it from GetBalance and receive it when they
+	// are first written, but keep not existing until then. Deleted accounts
+	// are not unknown, they start over from zero.
+	AutoFund *big.Int
+
+	// Backend, if set, supplies the accounts and storage slots the state has
+	// never seen. They are fetched on first access and kept, so the state
+	// only ever contains (and roots and dumps only cover) what was touched.
+	Backend Backend
 }
 
 func New() *StateDB {
-	return &StateDB{map[common.Address]*State{}, []*types.Log{}}
+	return NewWithConfig(Config{})
+}
+
+// NewWithConfig creates an empty state using the given options.
+func NewWithConfig(cfg Config) *StateDB {
+	return &StateDB{StateMap: map[common.Address]*State{}, Logs: []*types.Log{}, config: cfg, preimages: map[common.Hash][]byte{}, missing: map[common.Address]struct{}{}}
+}
+
+func newState(addr common.Address) *State {
+	return &State{address: addr, balance: big.NewInt(0), storage: map[common.Hash]common.Hash{}}
+}
+
+// initialBalance is the balance an account that has never been seen starts with.
+func (st *StateDB) initialBalance() *big.Int {
+	if st.config.AutoFund != nil {
+		return new(big.Int).Set(st.config.AutoFund)
+	}
+	return big.NewInt(0)
+}
+
+// fork returns a copy of the account whose storage starts empty and falls
+// through to the original.
+func (s *State) fork() *State {
+	return &State{
+		address:   s.address,
+		balance:   s.balance,
+		nonce:     s.nonce,
+		codeHash:  s.codeHash,
+		code:      s.code,
+		isSuicide: s.isSuicide,
+		storage:   map[common.Hash]common.Hash{},
+		parent:    s,
+	}
+}
+
+// empty returns whether the account is considered empty (EIP161).
+func (s *State) empty() bool {
+	return s.nonce == 0 && s.balance.Sign() == 0 && len(s.code) == 0
+}
+
+// getStorage returns the value of key as seen by this layer.
+func (s *State) getStorage(key common.Hash) (common.Hash, bool) {
+	for ; ; s = s.parent {
+		if val, ok := s.storage[key]; ok {
+			return val, true
+		}
+		if s.parent == nil {
+			break
+		}
+	}
+	if s.origin != nil {
+		return s.origin.fetchStorage(s, key), true
+	}
+	return common.Hash{}, false
 }
 
 func (st State) String() string {
@@ -52,141 +155,472 @@ func (st *State) Backup() {
 func (st *StateDB) Print() {
 	fmt.Printf("\nstatedb:\n")
 	for _, s := range st.StateMap {
-		fmt.Printf("%s\n", s)
+		if s != nil {
+			fmt.Printf("%s\n", s)
+		}
+	}
+}
+
+// Fork returns a new state layered on top of st. Reads fall through to st
+// until the fork writes an account, so forking costs O(1) and every change
+// costs O(writes). st must not be modified while forks of it are in use.
+func (st *StateDB) Fork() *StateDB {
+	return &StateDB{
+		StateMap:  map[common.Address]*State{},
+		Logs:      st.Logs[:len(st.Logs):len(st.Logs)],
+		config:    st.config,
+		parent:    st,
+		refund:    st.refund,
+		preimages: map[common.Hash][]byte{},
+	}
+}
+
+// Discard drops all changes made in the fork and returns the state it was
+// forked from. The discarded fork must not be used afterwards.
+func (st *StateDB) Discard() *StateDB {
+	parent := st.parent
+	*st = StateDB{}
+	return parent
+}
+
+// getStateObject returns the state of addr as seen by this layer, or nil.
+func (st *StateDB) getStateObject(addr common.Address) *State {
+	s, _ := st.lookup(addr)
+	return s
+}
+
+// lookup returns the state of addr as seen by this layer. If it is nil,
+// deleted tells whether the account was deleted rather than never seen.
+func (st *StateDB) lookup(addr common.Address) (s *State, deleted bool) {
+	for ; st != nil; st = st.parent {
+		if s, ok := st.StateMap[addr]; ok {
+			return s, s == nil
+		}
+		if st.parent == nil {
+			return st.fetchState(addr), false
+		}
+	}
+	return nil, false
+}
+
+// fetchState loads addr from the backend into the root layer unless it is
+// there already. It returns nil if the account does not exist in either.
+func (st *StateDB) fetchState(addr common.Address) *State {
+	if s, ok := st.StateMap[addr]; ok {
+		return s
+	}
+	if st.config.Backend == nil {
+		return nil
+	}
+	if _, ok := st.missing[addr]; ok {
+		return nil
+	}
+	account, err := st.config.Backend.Account(addr)
+	if err != nil {
+		st.setError(err)
+		return nil
+	}
+	if account == nil {
+		st.missing[addr] = struct{}{}
+		return nil
+	}
+	s := newState(addr)
+	s.balance = new(big.Int).Set(account.Balance)
+	s.nonce = account.Nonce
+	if len(account.Code) > 0 {
+		s.code = account.Code
+		s.codeHash = crypto.Keccak256Hash(account.Code)
+	}
+	s.origin = st
+	st.StateMap[addr] = s
+	return s
+}
+
+// fetchStorage loads the slot key of s from the backend and keeps it in s.
+func (st *StateDB) fetchStorage(s *State, key common.Hash) common.Hash {
+	val, err := st.config.Backend.Storage(s.address, key)
+	if err != nil {
+		st.setError(err)
+		return common.Hash{}
+	}
+	s.storage[key] = val
+	return val
+}
+
+// setError remembers the first error in the root layer.
+func (st *StateDB) setError(err error) {
+	for st.parent != nil {
+		st = st.parent
+	}
+	if st.dbErr == nil {
+		st.dbErr = err
+	}
+}
+
+// Error returns the first error the backend returned or the first balance
+// underflow, if any. Accounts and slots that failed to load read as missing.
+func (st *StateDB) Error() error {
+	for st.parent != nil {
+		st = st.parent
+	}
+	return st.dbErr
+}
+
+// getOrNewState returns the state of addr owned by this layer, copying it from
+// the parent or creating (and journaling) an empty one if needed.
+func (st *StateDB) getOrNewState(addr common.Address) *State {
+	prev, deleted := st.StateMap[addr]
+	if prev != nil {
+		return prev
 	}
+	var s *State
+	if deleted {
+		s = newState(addr)
+		st.journal = append(st.journal, resetObjectChange{account: addr})
+		st.StateMap[addr] = s
+		return s
+	}
+	if st.parent == nil {
+		if s := st.fetchState(addr); s != nil {
+			return s
+		}
+	} else if prev, deleted := st.parent.lookup(addr); prev != nil {
+		s = prev.fork()
+	} else if deleted {
+		s = newState(addr)
+	}
+	if s == nil {
+		s = newState(addr)
+		s.balance = st.initialBalance()
+	}
+	st.journal = append(st.journal, createObjectChange{account: addr})
+	st.StateMap[addr] = s
+	return s
 }
 
+// CreateAccount explicitly creates a state object. If a state object with the
+// address already exists the balance is carried over to the new account.
 func (st *StateDB) CreateAccount(addr common.Address) {
-	st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
+	if st.parent == nil {
+		st.fetchState(addr)
+	}
+	s := newState(addr)
+	if prev, ok := st.StateMap[addr]; ok {
+		if prev != nil {
+			s.balance = prev.balance
+		}
+		st.journal = append(st.journal, resetObjectChange{account: addr, prev: prev})
+	} else {
+		if prev, deleted := st.parent.lookup(addr); prev != nil {
+			s.balance = prev.balance
+		} else if !deleted {
+			s.balance = st.initialBalance()
+		}
+		st.journal = append(st.journal, createObjectChange{account: addr})
+	}
+	st.StateMap[addr] = s
 	return
 }
 
+// SubBalance subtracts value from the balance of addr. A balance can't go
+// negative, it is clamped at zero and the underflow is reported by Error.
 func (st *StateDB) SubBalance(addr common.Address, value *big.Int) {
-	if _, ok := st.StateMap[addr]; !ok {
-		return
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, balanceChange{account: addr, prev: s.balance})
+	s.balance = new(big.Int).Sub(s.balance, value)
+	if s.balance.Sign() < 0 {
+		st.setError(fmt.Errorf("balance of %x underflows by %v", addr[:], new(big.Int).Neg(s.balance)))
+		s.balance.SetUint64(0)
 	}
-	st.StateMap[addr].balance.Set(new(big.Int).Sub(st.StateMap[addr].balance, value))
 	return
 }
 func (st *StateDB) AddBalance(addr common.Address, value *big.Int) {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
-	}
-	st.StateMap[addr].balance.Set(new(big.Int).Add(st.StateMap[addr].balance, value))
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, balanceChange{account: addr, prev: s.balance})
+	s.balance = new(big.Int).Add(s.balance, value)
 	return
 }
+
+// SetBalance sets the balance of addr to exactly value.
+func (st *StateDB) SetBalance(addr common.Address, value *big.Int) {
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, balanceChange{account: addr, prev: s.balance})
+	s.balance = new(big.Int).Set(value)
+}
+
+// GetBalance returns the balance of addr. The returned value must not be
+// modified by the caller.
 func (st *StateDB) GetBalance(addr common.Address) *big.Int {
-	st.AddBalance(addr, big.NewInt(int64(100)))
-	if _, ok := st.StateMap[addr]; !ok {
-		return nil
+	s, deleted := st.lookup(addr)
+	if deleted {
+		return new(big.Int)
 	}
-	return st.StateMap[addr].balance
+	if s == nil {
+		return st.initialBalance()
+	}
+	return s.balance
 }
 
 func (st *StateDB) GetNonce(addr common.Address) uint64 {
-	if _, ok := st.StateMap[addr]; !ok {
+	s := st.getStateObject(addr)
+	if s == nil {
 		return 0
 	}
-	return st.StateMap[addr].nonce
+	return s.nonce
 }
 func (st *StateDB) SetNonce(addr common.Address, value uint64) {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
-	}
-	st.StateMap[addr].nonce = value
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, nonceChange{account: addr, prev: s.nonce})
+	s.nonce = value
 	return
 }
 
 func (st *StateDB) GetCodeHash(addr common.Address) common.Hash {
-	if _, ok := st.StateMap[addr]; !ok {
+	s := st.getStateObject(addr)
+	if s == nil {
 		return common.Hash{}
 	}
-	return st.StateMap[addr].codeHash
+	return s.codeHash
 }
 func (st *StateDB) GetCode(addr common.Address) []byte {
-	if _, ok := st.StateMap[addr]; !ok {
+	s := st.getStateObject(addr)
+	if s == nil {
 		return nil
 	}
-	return st.StateMap[addr].code
+	return s.code
 }
 func (st *StateDB) SetCode(addr common.Address, code []byte) {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
-	}
-	st.StateMap[addr].code = code
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, codeChange{account: addr, prevcode: s.code, prevhash: s.codeHash})
+	s.code = code
+	s.codeHash = crypto.Keccak256Hash(code)
 	return
 }
 func (st *StateDB) GetCodeSize(addr common.Address) int {
-	if _, ok := st.StateMap[addr]; !ok {
+	s := st.getStateObject(addr)
+	if s == nil {
 		return 0
 	}
-	code := st.StateMap[addr].code
-	return len(code)
+	return len(s.code)
 }
 
-func (st *StateDB) AddRefund(uint64) {
+func (st *StateDB) AddRefund(gas uint64) {
+	st.journal = append(st.journal, refundChange{prev: st.refund})
+	st.refund += gas
 	return
 }
 func (st *StateDB) GetRefund() uint64 {
-	return 0
+	return st.refund
 }
 
 func (st *StateDB) GetState(addr common.Address, key common.Hash) common.Hash {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
-	}
-	if _, ok := st.StateMap[addr].storage[key]; !ok {
+	s := st.getStateObject(addr)
+	if s == nil {
 		return common.Hash{}
 	}
-	return st.StateMap[addr].storage[key]
+	val, _ := s.getStorage(key)
+	return val
 }
 func (st *StateDB) SetState(addr common.Address, key common.Hash, value common.Hash) {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
-	}
-	st.StateMap[addr].storage[key] = value
+	s := st.getOrNewState(addr)
+	prev, existed := s.storage[key]
+	st.journal = append(st.journal, storageChange{account: addr, key: key, prevalue: prev, existed: existed})
+	s.storage[key] = value
 	return
 }
 
+// Suicide marks the given account as suicided and clears its balance. The
+// account is still available until Finalise, Exist will keep returning true
+// for it and its code can still be called within the same message.
 func (st *StateDB) Suicide(addr common.Address) bool {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
+	if st.getStateObject(addr) == nil {
+		return false
 	}
-	st.StateMap[addr].isSuicide = true
+	s := st.getOrNewState(addr)
+	st.journal = append(st.journal, suicideChange{account: addr, prev: s.isSuicide, prevbalance: s.balance})
+	s.isSuicide = true
+	s.balance = new(big.Int)
 	return true
 }
 func (st *StateDB) HasSuicided(addr common.Address) bool {
-	if _, ok := st.StateMap[addr]; !ok {
-		st.StateMap[addr] = &State{addr, big.NewInt(0), 0, common.Hash{}, nil, false, map[common.Hash]common.Hash{}}
+	s := st.getStateObject(addr)
+	if s == nil {
+		return false
 	}
-	return st.StateMap[addr].isSuicide
+	return s.isSuicide
 }
 
-func (st *StateDB) Exist(common.Address) bool {
-	return true
+// Addresses returns the addresses of all live accounts in ascending order.
+// Suicided accounts are left out.
+func (st *StateDB) Addresses() []common.Address {
+	var addrs []common.Address
+	for addr, s := range st.accounts() {
+		if !s.isSuicide {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
 }
 
-func (st *StateDB) Empty(common.Address) bool {
-	return true
+// Exist reports whether the given account exists in state.
+// Notably this also returns true for suicided accounts.
+func (st *StateDB) Exist(addr common.Address) bool {
+	return st.getStateObject(addr) != nil
 }
 
-func (st *StateDB) RevertToSnapshot(int) {
-	return
+// Empty returns whether the given account is empty or doesn't exist. Empty
+// is defined according to EIP161 (balance = nonce = code = 0), an unknown
+// account holding the AutoFund balance is not empty, a deleted one is.
+func (st *StateDB) Empty(addr common.Address) bool {
+	s, deleted := st.lookup(addr)
+	if s == nil {
+		return deleted || st.initialBalance().Sign() == 0
+	}
+	return s.empty()
 }
+
+// Snapshot returns an identifier for the current revision of the state.
 func (st *StateDB) Snapshot() int {
-	return 0
+	id := st.nextRevisionId
+	st.nextRevisionId++
+	st.validRevisions = append(st.validRevisions, revision{id, len(st.journal)})
+	return id
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (st *StateDB) RevertToSnapshot(revid int) {
+	// Find the snapshot in the stack of valid snapshots.
+	idx := sort.Search(len(st.validRevisions), func(i int) bool {
+		return st.validRevisions[i].id >= revid
+	})
+	if idx == len(st.validRevisions) || st.validRevisions[idx].id != revid {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	snapshot := st.validRevisions[idx].journalIndex
+
+	// Replay the journal to undo changes.
+	for i := len(st.journal) - 1; i >= snapshot; i-- {
+		st.journal[i].undo(st)
+	}
+	st.journal = st.journal[:snapshot]
+
+	// Remove invalidated snapshots from the stack.
+	st.validRevisions = st.validRevisions[:idx]
+}
+
+// Finalise deletes the accounts that suicided and, if deleteEmptyObjects is
+// set (EIP158), the empty ones touched once a top level message has been
+// applied. It then drops the journal and the refund counter, snapshots taken
+// before can't be reverted anymore.
+func (st *StateDB) Finalise(deleteEmptyObjects bool) {
+	for addr := range st.journal.dirties() {
+		s := st.StateMap[addr]
+		if s == nil || !(s.isSuicide || (deleteEmptyObjects && s.empty())) {
+			continue
+		}
+		st.StateMap[addr] = nil
+	}
+	st.journal = nil
+	st.validRevisions = st.validRevisions[:0]
+	st.refund = 0
 }
 
 func (st *StateDB) AddLog(eventLog *types.Log) {
+	st.journal = append(st.journal, addLogChange{})
 	st.Logs = append(st.Logs, eventLog)
 	return
 }
-func (st *StateDB) AddPreimage(common.Hash, []byte) {
-	return
+
+// AddPreimage records a SHA3 preimage seen by the VM.
+func (st *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	if _, ok := st.Preimage(hash); ok {
+		return
+	}
+	st.journal = append(st.journal, addPreimageChange{hash: hash})
+	pi := make([]byte, len(preimage))
+	copy(pi, preimage)
+	st.preimages[hash] = pi
 }
 
-func (st *StateDB) ForEachStorage(common.Address, func(common.Hash, common.Hash) bool) {
-	return
+// Preimage returns the recorded preimage of hash, if any.
+func (st *StateDB) Preimage(hash common.Hash) ([]byte, bool) {
+	for ; st != nil; st = st.parent {
+		if pi, ok := st.preimages[hash]; ok {
+			return pi, true
+		}
+	}
+	return nil, false
+}
+
+// Preimages returns all recorded preimages, including the ones of the
+// state this one was forked from.
+func (st *StateDB) Preimages() map[common.Hash][]byte {
+	preimages := make(map[common.Hash][]byte)
+	for ; st != nil; st = st.parent {
+		for hash, pi := range st.preimages {
+			if _, ok := preimages[hash]; !ok {
+				preimages[hash] = pi
+			}
+		}
+	}
+	return preimages
+}
+
+// allStorage returns all storage slots of the account as seen by this layer.
+func (s *State) allStorage() map[common.Hash]common.Hash {
+	storage := make(map[common.Hash]common.Hash)
+	for ; s != nil; s = s.parent {
+		for key, val := range s.storage {
+			if _, ok := storage[key]; !ok {
+				storage[key] = val
+			}
+		}
+	}
+	return storage
+}
+
+// ForEachStorage calls cb for every non-empty storage slot of addr in
+// ascending key order, until cb returns false.
+func (st *StateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	s := st.getStateObject(addr)
+	if s == nil {
+		return
+	}
+	storage := s.allStorage()
+	keys := make([]common.Hash, 0, len(storage))
+	for key, val := range storage {
+		if val != (common.Hash{}) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	for _, key := range keys {
+		if !cb(key, storage[key]) {
+			return
+		}
+	}
+}
+
+// ForEachDirtyStorage calls cb for every storage slot of addr written in this
+// layer, zero values included, in ascending key order, until cb returns false.
+// Unlike the journal the slots survive Finalise, in a fork they are the slots
+// written since Fork.
+func (st *StateDB) ForEachDirtyStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	s := st.StateMap[addr]
+	if s == nil {
+		return
+	}
+	keys := make([]common.Hash, 0, len(s.storage))
+	for key := range s.storage {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	for _, key := range keys {
+		if !cb(key, s.storage[key]) {
+			return
+		}
+	}
 }
 
 func (st *StateDB) Backup() {
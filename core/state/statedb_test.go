@@ -0,0 +1,422 @@
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"minievm/common"
+	"minievm/core/types"
+	"minievm/crypto"
+	"minievm/ethdb"
+	"testing"
+)
+
+func TestSnapshotRevert(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("snapshot"))
+	key := common.BytesToHash([]byte("key"))
+
+	st.AddBalance(addr, big.NewInt(42))
+	st.SetState(addr, key, common.BytesToHash([]byte{1}))
+
+	snap := st.Snapshot()
+	st.AddBalance(addr, big.NewInt(8))
+	st.SetNonce(addr, 7)
+	st.SetCode(addr, []byte{0x60, 0x00})
+	st.SetState(addr, key, common.BytesToHash([]byte{2}))
+	st.SetState(addr, common.BytesToHash([]byte("other")), common.BytesToHash([]byte{3}))
+	st.AddLog(&types.Log{Address: addr})
+	st.AddRefund(100)
+	st.RevertToSnapshot(snap)
+
+	if balance := st.StateMap[addr].balance; balance.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("balance mismatch: have %v, want 42", balance)
+	}
+	if nonce := st.GetNonce(addr); nonce != 0 {
+		t.Errorf("nonce mismatch: have %d, want 0", nonce)
+	}
+	if code := st.GetCode(addr); code != nil {
+		t.Errorf("code mismatch: have %x, want nil", code)
+	}
+	if val := st.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Errorf("storage mismatch: have %x, want 01", val)
+	}
+	if _, ok := st.StateMap[addr].storage[common.BytesToHash([]byte("other"))]; ok {
+		t.Errorf("storage slot written after snapshot survived revert")
+	}
+	if len(st.Logs) != 0 {
+		t.Errorf("log count mismatch: have %d, want 0", len(st.Logs))
+	}
+	if refund := st.GetRefund(); refund != 0 {
+		t.Errorf("refund mismatch: have %d, want 0", refund)
+	}
+}
+
+func TestNestedSnapshots(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("nested"))
+	key := common.BytesToHash([]byte("key"))
+
+	outer := st.Snapshot()
+	st.SetState(addr, key, common.BytesToHash([]byte{1}))
+	inner := st.Snapshot()
+	st.SetState(addr, key, common.BytesToHash([]byte{2}))
+
+	st.RevertToSnapshot(inner)
+	if val := st.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Errorf("inner revert: have %x, want 01", val)
+	}
+	st.RevertToSnapshot(outer)
+	if _, ok := st.StateMap[addr]; ok {
+		t.Errorf("account created after outer snapshot survived revert")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("reverting an invalidated snapshot should panic")
+		}
+	}()
+	st.RevertToSnapshot(inner)
+}
+
+func TestSuicideRevert(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("suicide"))
+	st.AddBalance(addr, big.NewInt(10))
+
+	snap := st.Snapshot()
+	if !st.Suicide(addr) {
+		t.Fatalf("suicide of existing account failed")
+	}
+	if !st.HasSuicided(addr) || st.StateMap[addr].balance.Sign() != 0 {
+		t.Fatalf("suicided account should be marked and drained")
+	}
+	st.RevertToSnapshot(snap)
+	if st.HasSuicided(addr) || st.StateMap[addr].balance.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("suicide survived revert")
+	}
+}
+
+func TestFinaliseDeletes(t *testing.T) {
+	st := New()
+	dead := common.BytesToAddress([]byte("dead"))
+	touched := common.BytesToAddress([]byte("touched"))
+	st.SetCode(dead, []byte{0x60, 0x00})
+	st.AddBalance(dead, big.NewInt(10))
+	st.Finalise(true)
+
+	fork := st.Fork()
+	fork.Suicide(dead)
+	fork.AddBalance(touched, new(big.Int))
+	if !fork.Exist(dead) {
+		t.Fatalf("suicided account gone before finalise")
+	}
+	fork.Finalise(true)
+	if fork.Exist(dead) || fork.GetCodeSize(dead) != 0 {
+		t.Errorf("suicided account survived finalise")
+	}
+	if fork.Exist(touched) {
+		t.Errorf("empty touched account survived finalise")
+	}
+	if len(fork.Addresses()) != 0 {
+		t.Errorf("address count mismatch: have %d, want 0", len(fork.Addresses()))
+	}
+	if !st.Exist(dead) {
+		t.Errorf("deletion in fork leaked into parent")
+	}
+
+	snap := fork.Snapshot()
+	fork.AddBalance(dead, big.NewInt(1))
+	if fork.GetCodeSize(dead) != 0 {
+		t.Errorf("recreated account kept its code")
+	}
+	fork.RevertToSnapshot(snap)
+	if fork.Exist(dead) {
+		t.Errorf("recreation survived revert")
+	}
+
+	st.Suicide(dead)
+	st.Finalise(true)
+	if st.Exist(dead) {
+		t.Errorf("suicided account survived finalise in root layer")
+	}
+}
+
+func TestCreateAccountRevert(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("create"))
+	st.AddBalance(addr, big.NewInt(5))
+	st.SetNonce(addr, 3)
+
+	snap := st.Snapshot()
+	st.CreateAccount(addr)
+	if nonce := st.GetNonce(addr); nonce != 0 {
+		t.Errorf("recreated account kept nonce %d", nonce)
+	}
+	if balance := st.StateMap[addr].balance; balance.Cmp(big.NewInt(5)) != 0 {
+		t.Errorf("recreated account lost balance: have %v, want 5", balance)
+	}
+	st.RevertToSnapshot(snap)
+	if nonce := st.GetNonce(addr); nonce != 3 {
+		t.Errorf("nonce mismatch after revert: have %d, want 3", nonce)
+	}
+}
+
+func TestForkIsolation(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("fork"))
+	key := common.BytesToHash([]byte("key"))
+	st.AddBalance(addr, big.NewInt(10))
+	st.SetState(addr, key, common.BytesToHash([]byte{1}))
+	st.AddLog(&types.Log{Address: addr})
+
+	fork := st.Fork()
+	if val := fork.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Fatalf("fork doesn't see parent storage: have %x", val)
+	}
+	fork.SetState(addr, key, common.BytesToHash([]byte{2}))
+	fork.SubBalance(addr, big.NewInt(10))
+	fork.SetNonce(common.BytesToAddress([]byte("new")), 1)
+	fork.AddLog(&types.Log{Address: addr})
+
+	if val := st.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Errorf("fork write leaked into parent storage: have %x", val)
+	}
+	if balance := st.StateMap[addr].balance; balance.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("fork write leaked into parent balance: have %v", balance)
+	}
+	if _, ok := st.StateMap[common.BytesToAddress([]byte("new"))]; ok {
+		t.Errorf("account created in fork leaked into parent")
+	}
+	if len(st.Logs) != 1 || len(fork.Logs) != 2 {
+		t.Errorf("log count mismatch: parent %d, fork %d", len(st.Logs), len(fork.Logs))
+	}
+
+	// Sibling forks and forks of forks only see their own branch.
+	sibling := st.Fork()
+	child := fork.Fork()
+	if val := sibling.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Errorf("sibling sees other branch: have %x", val)
+	}
+	if val := child.GetState(addr, key); val != common.BytesToHash([]byte{2}) {
+		t.Errorf("child doesn't see its parent fork: have %x", val)
+	}
+
+	if parent := fork.Discard(); parent != st {
+		t.Errorf("discard returned wrong parent")
+	}
+}
+
+func TestForkRevert(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("fork"))
+	key := common.BytesToHash([]byte("key"))
+	st.SetState(addr, key, common.BytesToHash([]byte{1}))
+
+	fork := st.Fork()
+	snap := fork.Snapshot()
+	fork.SetState(addr, key, common.BytesToHash([]byte{2}))
+	fork.CreateAccount(addr)
+	if val := fork.GetState(addr, key); val != (common.Hash{}) {
+		t.Errorf("recreated account kept parent storage: have %x", val)
+	}
+	fork.RevertToSnapshot(snap)
+	if val := fork.GetState(addr, key); val != common.BytesToHash([]byte{1}) {
+		t.Errorf("revert in fork: have %x, want 01", val)
+	}
+}
+
+func TestExistEmpty(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("exist"))
+
+	if st.Exist(addr) || !st.Empty(addr) {
+		t.Fatalf("unknown account should not exist and be empty")
+	}
+	if balance := st.GetBalance(addr); balance.Sign() != 0 {
+		t.Errorf("unknown account balance: have %v, want 0", balance)
+	}
+	if st.Exist(addr) {
+		t.Errorf("GetBalance created the account")
+	}
+
+	st.CreateAccount(addr)
+	if !st.Exist(addr) || !st.Empty(addr) {
+		t.Errorf("created account should exist and be empty")
+	}
+	st.SetNonce(addr, 1)
+	if st.Empty(addr) {
+		t.Errorf("account with nonce should not be empty")
+	}
+	st.SetNonce(addr, 0)
+	st.SetCode(addr, []byte{0x00})
+	if st.Empty(addr) {
+		t.Errorf("account with code should not be empty")
+	}
+
+	st.Suicide(addr)
+	if !st.Exist(addr) {
+		t.Errorf("suicided account should still exist")
+	}
+}
+
+func TestAutoFund(t *testing.T) {
+	st := NewWithConfig(Config{AutoFund: big.NewInt(100)})
+	addr := common.BytesToAddress([]byte("funded"))
+
+	for i := 0; i < 2; i++ {
+		if balance := st.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+			t.Fatalf("read %d: have %v, want 100", i, balance)
+		}
+	}
+	if st.Exist(addr) {
+		t.Errorf("reading an auto-funded balance created the account")
+	}
+	if st.Empty(addr) {
+		t.Errorf("auto-funded account reported empty")
+	}
+	st.SubBalance(addr, big.NewInt(30))
+	if balance := st.GetBalance(addr); balance.Cmp(big.NewInt(70)) != 0 {
+		t.Errorf("balance after sub: have %v, want 70", balance)
+	}
+	if !st.Exist(addr) {
+		t.Errorf("written account should exist")
+	}
+}
+
+func TestDeletedAutoFund(t *testing.T) {
+	for _, forked := range []bool{false, true} {
+		st := NewWithConfig(Config{AutoFund: big.NewInt(1000)})
+		addr := common.BytesToAddress([]byte("deleted"))
+		st.SetCode(addr, []byte{0x00})
+		st.Finalise(true)
+		if forked {
+			st = st.Fork()
+		}
+		st.Suicide(addr)
+		st.Finalise(true)
+
+		if st.Exist(addr) || !st.Empty(addr) || st.GetBalance(addr).Sign() != 0 {
+			t.Errorf("forked %v: deleted account reads as exist %v, empty %v, balance %v", forked, st.Exist(addr), st.Empty(addr), st.GetBalance(addr))
+		}
+		child := st.Fork()
+		if child.Exist(addr) || !child.Empty(addr) || child.GetBalance(addr).Sign() != 0 {
+			t.Errorf("forked %v: deleted account reads through a fork as exist %v, empty %v, balance %v", forked, child.Exist(addr), child.Empty(addr), child.GetBalance(addr))
+		}
+		child.AddBalance(addr, new(big.Int))
+		if balance := child.GetBalance(addr); balance.Sign() != 0 {
+			t.Errorf("forked %v: account recreated in a fork has balance %v", forked, balance)
+		}
+		child = st.Fork()
+		child.CreateAccount(addr)
+		if balance := child.GetBalance(addr); balance.Sign() != 0 {
+			t.Errorf("forked %v: account created in a fork has balance %v", forked, balance)
+		}
+		st.AddBalance(addr, new(big.Int))
+		if balance := st.GetBalance(addr); balance.Sign() != 0 {
+			t.Errorf("forked %v: recreated account has balance %v", forked, balance)
+		}
+	}
+}
+
+func TestSubBalanceUnderflow(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("poor"))
+	fork := st.Fork()
+	fork.SubBalance(addr, big.NewInt(5))
+	if balance := fork.GetBalance(addr); balance.Sign() != 0 {
+		t.Errorf("balance went negative: have %v", balance)
+	}
+	if st.Error() == nil {
+		t.Errorf("underflow not reported")
+	}
+	db, _ := ethdb.NewMemDatabase()
+	if _, err := fork.Save(db); err != nil {
+		t.Errorf("save after underflow: %v", err)
+	}
+}
+
+func TestForEachStorage(t *testing.T) {
+	st := New()
+	addr := common.BytesToAddress([]byte("storage"))
+	st.SetState(addr, common.BytesToHash([]byte{2}), common.BytesToHash([]byte{0x22}))
+	st.SetState(addr, common.BytesToHash([]byte{1}), common.BytesToHash([]byte{0x11}))
+	st.SetState(addr, common.BytesToHash([]byte{3}), common.BytesToHash([]byte{0x33}))
+
+	fork := st.Fork()
+	fork.SetState(addr, common.BytesToHash([]byte{3}), common.Hash{})
+	fork.SetState(addr, common.BytesToHash([]byte{4}), common.BytesToHash([]byte{0x44}))
+
+	var keys []byte
+	fork.ForEachStorage(addr, func(key, value common.Hash) bool {
+		if value[31] != key[31]*0x11 {
+			t.Errorf("slot %x: have %x", key, value)
+		}
+		keys = append(keys, key[31])
+		return true
+	})
+	if !bytes.Equal(keys, []byte{1, 2, 4}) {
+		t.Errorf("iterated slots mismatch: have %v, want [1 2 4]", keys)
+	}
+
+	var visited int
+	fork.ForEachStorage(addr, func(key, value common.Hash) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("iteration didn't stop: visited %d slots", visited)
+	}
+
+	keys = nil
+	fork.Finalise(false)
+	fork.ForEachDirtyStorage(addr, func(key, value common.Hash) bool {
+		keys = append(keys, key[31])
+		return true
+	})
+	if !bytes.Equal(keys, []byte{3, 4}) {
+		t.Errorf("dirty slots mismatch: have %v, want [3 4]", keys)
+	}
+}
+
+func TestPreimages(t *testing.T) {
+	st := New()
+	preimage := []byte("preimage")
+	hash := crypto.Keccak256Hash(preimage)
+
+	snap := st.Snapshot()
+	st.AddPreimage(hash, preimage)
+	if pi, ok := st.Preimage(hash); !ok || !bytes.Equal(pi, preimage) {
+		t.Fatalf("preimage mismatch: have %q", pi)
+	}
+	st.RevertToSnapshot(snap)
+	if _, ok := st.Preimage(hash); ok {
+		t.Errorf("preimage survived revert")
+	}
+
+	st.AddPreimage(hash, preimage)
+	fork := st.Fork()
+	if _, ok := fork.Preimage(hash); !ok {
+		t.Errorf("fork doesn't see parent preimage")
+	}
+	if len(fork.Preimages()) != 1 {
+		t.Errorf("preimage count mismatch: have %d, want 1", len(fork.Preimages()))
+	}
+}
+
+func TestRefund(t *testing.T) {
+	st := New()
+	st.AddRefund(10)
+	snap := st.Snapshot()
+	st.AddRefund(5)
+	if refund := st.GetRefund(); refund != 15 {
+		t.Errorf("refund mismatch: have %d, want 15", refund)
+	}
+	st.RevertToSnapshot(snap)
+	if refund := st.GetRefund(); refund != 10 {
+		t.Errorf("refund after revert: have %d, want 10", refund)
+	}
+	st.Finalise(true)
+	if refund := st.GetRefund(); refund != 0 {
+		t.Errorf("refund after finalise: have %d, want 0", refund)
+	}
+}
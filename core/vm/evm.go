@@ -96,9 +96,6 @@ type Context struct {
 // specific errors should ever be performed. The interpreter makes
 // sure that any errors generated are to be considered faulty code.
 //
-
-type CallbackFunc func(args []interface{})
-
 // The EVM should never be reused and is not thread safe.
 type EVM struct {
 	// Context provides auxiliary blockchain related information
@@ -126,7 +123,8 @@ type EVM struct {
 	// applied in opCall*.
 	callGasTemp uint64
 
-	Callback CallbackFunc
+	// inspectors are notified of instrumented instructions.
+	inspectors []Inspector
 }
 
 // NewEVM retutrns a new EVM . The returned EVM is not thread safe and should
@@ -138,7 +136,6 @@ func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmCon
 		vmConfig:    vmConfig,
 		chainConfig: chainConfig,
 		chainRules:  chainConfig.Rules(ctx.BlockNumber),
-		Callback:    func(args []interface{}) {},
 	}
 
 	evm.interpreter = NewInterpreter(evm, vmConfig)
@@ -159,6 +156,11 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if evm.vmConfig.NoRecursion && evm.depth > 0 {
 		return nil, gas, nil
 	}
+	if evm.depth == 0 && len(evm.inspectors) > 0 {
+		ctx := topContext(CALL, caller.Address())
+		evm.inspectCallEnter(ctx, addr, input, gas, value)
+		defer func() { evm.inspectCallExit(ctx, ret, err) }()
+	}
 
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
@@ -347,6 +349,11 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 	evm.StateDB.SetNonce(caller.Address(), nonce+1)
 
 	contractAddr = crypto.CreateAddress(caller.Address(), nonce)
+	if evm.depth == 0 && len(evm.inspectors) > 0 {
+		ctx := topContext(CREATE, caller.Address())
+		evm.inspectCallEnter(ctx, contractAddr, code, gas, value)
+		defer func() { evm.inspectCallExit(ctx, ret, err) }()
+	}
 	contractHash := evm.StateDB.GetCodeHash(contractAddr)
 	if evm.StateDB.GetNonce(contractAddr) != 0 || (contractHash != (common.Hash{}) && contractHash != emptyCodeHash) {
 		return nil, common.Address{}, 0, ErrContractAddressCollision
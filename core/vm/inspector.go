@@ -0,0 +1,147 @@
+package vm
+
+import (
+	"math/big"
+
+	"minievm/common"
+)
+
+// OpContext describes the instruction an Inspector hook fires for.
+type OpContext struct {
+	Op       OpCode
+	Pc       uint64
+	Depth    int
+	Address  common.Address // address of the executing contract
+	CodeHash common.Hash    // hash of the executing code
+	Code     []byte         // executing code, must not be modified
+}
+
+// Inspector is notified of instrumented instructions while the EVM runs.
+// Big integer arguments belong to the interpreter and are only valid for the
+// duration of the call; inspectors must copy values they want to keep.
+type Inspector interface {
+	// StorageRead is called after SLOAD.
+	StorageRead(ctx OpContext, key common.Hash, value *big.Int)
+	// StorageWrite is called after SSTORE.
+	StorageWrite(ctx OpContext, key common.Hash, value *big.Int)
+	// Step is called before every instruction with the stack it runs on,
+	// bottom first. The stack must not be modified.
+	Step(ctx OpContext, stack []*big.Int)
+	// CallEnter is called before CALL, CALLCODE, DELEGATECALL, STATICCALL
+	// and CREATE run the callee, a CREATE enters the new address with the
+	// init code as input. It is also called for the top level EVM.Call and
+	// EVM.Create, with a context at depth 0 whose Address is the caller and
+	// whose Op is CALL or CREATE.
+	CallEnter(ctx OpContext, to common.Address, input []byte, gas uint64, value *big.Int)
+	// CallExit is called once the callee of CallEnter returned.
+	CallExit(ctx OpContext, ret []byte, err error)
+	// Arithmetic is called after ADD, SUB, MUL, DIV, SDIV, MOD, SMOD, EXP,
+	// ADDMOD, MULMOD, SIGNEXTEND and AND with the operands as popped and the
+	// pushed result.
+	Arithmetic(ctx OpContext, args []*big.Int, result *big.Int)
+	// Comparison is called after LT, GT, SLT, SGT and EQ with the operands
+	// as popped and the pushed result.
+	Comparison(ctx OpContext, args []*big.Int, result *big.Int)
+	// Jump is called after JUMP and JUMPI to a valid destination. taken is
+	// false for a JUMPI whose condition was zero.
+	Jump(ctx OpContext, dest uint64, taken bool)
+	// Log is called after LOG0 to LOG4.
+	Log(ctx OpContext, topics []common.Hash, data []byte)
+	// Create is called after CREATE with the new address, which is the zero
+	// address if creation failed.
+	Create(ctx OpContext, addr common.Address, code []byte, value *big.Int, err error)
+	// Selfdestruct is called after SELFDESTRUCT.
+	Selfdestruct(ctx OpContext, beneficiary common.Address, balance *big.Int)
+}
+
+// NoopInspector implements Inspector with empty hooks. Embed it to only
+// implement the hooks of interest.
+type NoopInspector struct{}
+
+func (NoopInspector) StorageRead(ctx OpContext, key common.Hash, value *big.Int)  {}
+func (NoopInspector) StorageWrite(ctx OpContext, key common.Hash, value *big.Int) {}
+func (NoopInspector) Step(ctx OpContext, stack []*big.Int)                        {}
+func (NoopInspector) CallEnter(ctx OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+func (NoopInspector) CallExit(ctx OpContext, ret []byte, err error)              {}
+func (NoopInspector) Arithmetic(ctx OpContext, args []*big.Int, result *big.Int) {}
+func (NoopInspector) Comparison(ctx OpContext, args []*big.Int, result *big.Int) {}
+func (NoopInspector) Jump(ctx OpContext, dest uint64, taken bool)                {}
+func (NoopInspector) Log(ctx OpContext, topics []common.Hash, data []byte)       {}
+func (NoopInspector) Create(ctx OpContext, addr common.Address, code []byte, value *big.Int, err error) {
+}
+func (NoopInspector) Selfdestruct(ctx OpContext, beneficiary common.Address, balance *big.Int) {}
+
+// AddInspector registers i. Inspectors are notified in registration order.
+func (evm *EVM) AddInspector(i Inspector) {
+	evm.inspectors = append(evm.inspectors, i)
+}
+
+// RemoveInspector unregisters i, if it was registered.
+func (evm *EVM) RemoveInspector(i Inspector) {
+	for n, registered := range evm.inspectors {
+		if registered == i {
+			evm.inspectors = append(evm.inspectors[:n:n], evm.inspectors[n+1:]...)
+			return
+		}
+	}
+}
+
+// Inspectors returns the registered inspectors.
+func (evm *EVM) Inspectors() []Inspector {
+	return evm.inspectors
+}
+
+// topContext is the context of a top level call or create made by caller.
+func topContext(op OpCode, caller common.Address) OpContext {
+	return OpContext{Op: op, Address: caller}
+}
+
+func (evm *EVM) inspectCallEnter(ctx OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+	for _, i := range evm.inspectors {
+		i.CallEnter(ctx, to, input, gas, value)
+	}
+}
+
+func (evm *EVM) inspectCallExit(ctx OpContext, ret []byte, err error) {
+	for _, i := range evm.inspectors {
+		i.CallExit(ctx, ret, err)
+	}
+}
+
+func (evm *EVM) opContext(op OpCode, pc uint64, contract *Contract) OpContext {
+	return OpContext{Op: op, Pc: pc, Depth: evm.depth, Address: contract.Address(), CodeHash: contract.CodeHash, Code: contract.Code}
+}
+
+// captureArgs copies the operands of an arithmetic instruction before the
+// instruction overwrites them. It returns nil if nobody is inspecting.
+func (evm *EVM) captureArgs(args ...*big.Int) []*big.Int {
+	if len(evm.inspectors) == 0 {
+		return nil
+	}
+	copies := make([]*big.Int, len(args))
+	for i, arg := range args {
+		copies[i] = new(big.Int).Set(arg)
+	}
+	return copies
+}
+
+func (evm *EVM) inspectArithmetic(op OpCode, pc uint64, contract *Contract, args []*big.Int, result *big.Int) {
+	if args == nil {
+		return
+	}
+	ctx := evm.opContext(op, pc, contract)
+	for _, i := range evm.inspectors {
+		i.Arithmetic(ctx, args, result)
+	}
+}
+
+func (evm *EVM) inspectComparison(op OpCode, pc uint64, contract *Contract, args []*big.Int, result *big.Int) {
+	if args == nil {
+		return
+	}
+	ctx := evm.opContext(op, pc, contract)
+	for _, i := range evm.inspectors {
+		i.Comparison(ctx, args, result)
+	}
+}
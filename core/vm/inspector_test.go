@@ -0,0 +1,196 @@
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"minievm/common"
+	"minievm/core/state"
+	"minievm/crypto"
+	"minievm/params"
+)
+
+type recordingInspector struct {
+	NoopInspector
+	ops    []OpCode
+	reads  []common.Hash
+	writes map[common.Hash]uint64
+	sums   []uint64
+}
+
+func (r *recordingInspector) StorageRead(ctx OpContext, key common.Hash, value *big.Int) {
+	r.ops = append(r.ops, ctx.Op)
+	r.reads = append(r.reads, key)
+}
+
+func (r *recordingInspector) StorageWrite(ctx OpContext, key common.Hash, value *big.Int) {
+	r.ops = append(r.ops, ctx.Op)
+	r.writes[key] = value.Uint64()
+}
+
+func (r *recordingInspector) Arithmetic(ctx OpContext, args []*big.Int, result *big.Int) {
+	r.ops = append(r.ops, ctx.Op)
+	r.sums = append(r.sums, args[0].Uint64(), args[1].Uint64(), result.Uint64())
+}
+
+func (r *recordingInspector) Jump(ctx OpContext, dest uint64, taken bool) {
+	r.ops = append(r.ops, ctx.Op)
+}
+
+func TestInspectors(t *testing.T) {
+	statedb := state.New()
+	env := NewEVM(Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(0),
+	}, statedb, params.TestChainConfig, Config{})
+
+	// sstore(1, 2 + 3); sload(1); jump to the JUMPDEST; stop
+	code := []byte{
+		byte(PUSH1), 3, byte(PUSH1), 2, byte(ADD), byte(PUSH1), 1, byte(SSTORE),
+		byte(PUSH1), 1, byte(SLOAD), byte(POP),
+		byte(PUSH1), 15, byte(JUMP), byte(JUMPDEST), byte(STOP),
+	}
+	addr := common.HexToAddress("0xc0de")
+	statedb.SetCode(addr, code)
+
+	first := &recordingInspector{writes: map[common.Hash]uint64{}}
+	second := &recordingInspector{writes: map[common.Hash]uint64{}}
+	env.AddInspector(first)
+	env.AddInspector(second)
+	if _, _, err := env.Call(AccountRef(common.HexToAddress("0x1")), addr, nil, 100000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []OpCode{ADD, SSTORE, SLOAD, JUMP}
+	for _, r := range []*recordingInspector{first, second} {
+		if len(r.ops) != len(want) {
+			t.Fatalf("ops mismatch: have %v, want %v", r.ops, want)
+		}
+		for i := range want {
+			if r.ops[i] != want[i] {
+				t.Fatalf("ops mismatch: have %v, want %v", r.ops, want)
+			}
+		}
+		if r.sums[0] != 2 || r.sums[1] != 3 || r.sums[2] != 5 {
+			t.Errorf("arithmetic mismatch: %v", r.sums)
+		}
+		if r.writes[common.BigToHash(big.NewInt(1))] != 5 {
+			t.Errorf("storage write mismatch: %v", r.writes)
+		}
+	}
+
+	env.RemoveInspector(first)
+	if len(env.Inspectors()) != 1 || env.Inspectors()[0] != second {
+		t.Fatalf("remove failed: %v", env.Inspectors())
+	}
+}
+
+type callInspector struct {
+	NoopInspector
+	enters []OpContext
+	to     []common.Address
+	exits  int
+}
+
+func (c *callInspector) CallEnter(ctx OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+	c.enters = append(c.enters, ctx)
+	c.to = append(c.to, to)
+}
+
+func (c *callInspector) CallExit(ctx OpContext, ret []byte, err error) {
+	c.exits++
+}
+
+func TestInspectTopLevelCalls(t *testing.T) {
+	statedb := state.New()
+	env := NewEVM(Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(0),
+	}, statedb, params.TestChainConfig, Config{})
+
+	caller, outer, inner := common.HexToAddress("0x1"), common.HexToAddress("0xc0de"), common.HexToAddress("0xbeef")
+	// call(0xffff, 0xbeef, 0, 0, 0, 0, 0); stop
+	statedb.SetCode(outer, []byte{
+		byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0,
+		byte(PUSH2), 0xbe, 0xef, byte(PUSH2), 0xff, 0xff, byte(CALL), byte(STOP),
+	})
+	statedb.SetCode(inner, []byte{byte(STOP)})
+
+	c := &callInspector{}
+	env.AddInspector(c)
+	if _, _, err := env.Call(AccountRef(caller), outer, nil, 100000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.enters) != 2 || c.exits != 2 {
+		t.Fatalf("call count mismatch: %d enters, %d exits", len(c.enters), c.exits)
+	}
+	if top := c.enters[0]; top.Op != CALL || top.Depth != 0 || top.Address != caller || c.to[0] != outer {
+		t.Errorf("top level call mismatch: %+v to %x", top, c.to[0])
+	}
+	if nested := c.enters[1]; nested.Op != CALL || nested.Depth != 1 || nested.Address != outer || c.to[1] != inner {
+		t.Errorf("nested call mismatch: %+v to %x", nested, c.to[1])
+	}
+
+	c.enters, c.to, c.exits = nil, nil, 0
+	_, created, _, err := env.Create(AccountRef(caller), []byte{byte(STOP)}, 100000, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.enters) != 1 || c.exits != 1 || c.enters[0].Op != CREATE || c.to[0] != created {
+		t.Errorf("top level create mismatch: %+v to %x", c.enters, c.to)
+	}
+
+	// create(0, 0, 0); stop
+	factory := common.HexToAddress("0xfac7")
+	statedb.SetCode(factory, []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(CREATE), byte(STOP)})
+	child := crypto.CreateAddress(factory, statedb.GetNonce(factory))
+	c.enters, c.to, c.exits = nil, nil, 0
+	if _, _, err := env.Call(AccountRef(caller), factory, nil, 100000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.enters) != 2 || c.exits != 2 {
+		t.Fatalf("create count mismatch: %d enters, %d exits", len(c.enters), c.exits)
+	}
+	if nested := c.enters[1]; nested.Op != CREATE || nested.Depth != 1 || nested.Address != factory || c.to[1] != child {
+		t.Errorf("nested create mismatch: %+v to %x, want %x", nested, c.to[1], child)
+	}
+}
+
+type stepInspector struct {
+	NoopInspector
+	ops   []OpCode
+	sizes []int
+}
+
+func (s *stepInspector) Step(ctx OpContext, stack []*big.Int) {
+	s.ops = append(s.ops, ctx.Op)
+	s.sizes = append(s.sizes, len(stack))
+}
+
+func TestInspectSteps(t *testing.T) {
+	statedb := state.New()
+	env := NewEVM(Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(0),
+	}, statedb, params.TestChainConfig, Config{})
+
+	addr := common.HexToAddress("0xc0de")
+	statedb.SetCode(addr, []byte{byte(PUSH1), 1, byte(PUSH1), 2, byte(ADD), byte(STOP)})
+	s := &stepInspector{}
+	env.AddInspector(s)
+	if _, _, err := env.Call(AccountRef(common.HexToAddress("0x1")), addr, nil, 100000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	ops, sizes := []OpCode{PUSH1, PUSH1, ADD, STOP}, []int{0, 1, 2, 1}
+	if len(s.ops) != len(ops) {
+		t.Fatalf("steps mismatch: have %v, want %v", s.ops, ops)
+	}
+	for i := range ops {
+		if s.ops[i] != ops[i] || s.sizes[i] != sizes[i] {
+			t.Errorf("step %d mismatch: have %v with %d items, want %v with %d", i, s.ops[i], s.sizes[i], ops[i], sizes[i])
+		}
+	}
+}
@@ -38,77 +38,55 @@ var (
 
 func opAdd(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
-	result := x.Add(x, y)
-	var resultOrig big.Int
-	resultOrig.Set(result)
-	resultMod := math.U256(result)
-	stack.push(resultMod)
+	args := evm.captureArgs(x, y)
+	stack.push(math.U256(x.Add(x, y)))
 
 	evm.interpreter.intPool.put(y)
-	if resultOrig.Cmp(resultMod) != 0 {
-		key := "Nyanpass. Overflow detected: Add"
-		evm.StateDB.SetState(contract.Address(), common.StringToHash(key), common.BigToHash(big.NewInt(int64(*pc))))
-	}
+	evm.inspectArithmetic(ADD, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSub(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
-	result := x.Sub(x, y)
-	var resultOrig big.Int
-	resultOrig.Set(result)
-	resultMod := math.U256(result)
-	stack.push(resultMod)
+	args := evm.captureArgs(x, y)
+	stack.push(math.U256(x.Sub(x, y)))
 
 	evm.interpreter.intPool.put(y)
-	if resultOrig.Cmp(resultMod) != 0 {
-		key := "Nyanpass. Overflow detected: Sub"
-		evm.StateDB.SetState(contract.Address(), common.StringToHash(key), common.BigToHash(big.NewInt(int64(*pc))))
-	}
+	evm.inspectArithmetic(SUB, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opMul(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
-	result := x.Mul(x, y)
-	var resultOrig big.Int
-	resultOrig.Set(result)
-	resultMod := math.U256(result)
-	stack.push(resultMod)
+	args := evm.captureArgs(x, y)
+	stack.push(math.U256(x.Mul(x, y)))
 
 	evm.interpreter.intPool.put(y)
-	if resultOrig.Cmp(resultMod) != 0 {
-		key := "Nyanpass. Overflow detected: Mul"
-		evm.StateDB.SetState(contract.Address(), common.StringToHash(key), common.BigToHash(big.NewInt(int64(*pc))))
-	}
+	evm.inspectArithmetic(MUL, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opDiv(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	if y.Sign() != 0 {
-		result := x.Div(x, y)
-		var resultOrig big.Int
-		resultOrig.Set(result)
-		resultMod := math.U256(result)
-		if resultOrig.Cmp(resultMod) != 0 {
-			key := "Nyanpass. Overflow detected: Div"
-			evm.StateDB.SetState(contract.Address(), common.StringToHash(key), common.BigToHash(big.NewInt(int64(*pc))))
-		}
-		stack.push(resultMod)
+		stack.push(math.U256(x.Div(x, y)))
 	} else {
 		stack.push(new(big.Int))
 	}
 
 	evm.interpreter.intPool.put(y)
-
+	evm.inspectArithmetic(DIV, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSdiv(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	x, y := math.S256(stack.pop()), math.S256(stack.pop())
+	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
+	x, y = math.S256(x), math.S256(y)
 	if y.Sign() == 0 {
 		stack.push(new(big.Int))
+		evm.inspectArithmetic(SDIV, *pc, contract, args, stack.peek())
 		return nil, nil
 	} else {
 		n := new(big.Int)
@@ -124,22 +102,27 @@ func opSdiv(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 		stack.push(math.U256(res))
 	}
 	evm.interpreter.intPool.put(y)
+	evm.inspectArithmetic(SDIV, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opMod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	if y.Sign() == 0 {
 		stack.push(new(big.Int))
 	} else {
 		stack.push(math.U256(x.Mod(x, y)))
 	}
 	evm.interpreter.intPool.put(y)
+	evm.inspectArithmetic(MOD, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	x, y := math.S256(stack.pop()), math.S256(stack.pop())
+	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
+	x, y = math.S256(x), math.S256(y)
 
 	if y.Sign() == 0 {
 		stack.push(new(big.Int))
@@ -157,20 +140,23 @@ func opSmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 		stack.push(math.U256(res))
 	}
 	evm.interpreter.intPool.put(y)
+	evm.inspectArithmetic(SMOD, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opExp(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	base, exponent := stack.pop(), stack.pop()
+	args := evm.captureArgs(base, exponent)
 	stack.push(math.Exp(base, exponent))
 
 	evm.interpreter.intPool.put(base, exponent)
-
+	evm.inspectArithmetic(EXP, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSignExtend(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	back := stack.pop()
+	args := evm.captureArgs(back, stack.peek())
 	if back.Cmp(big.NewInt(31)) < 0 {
 		bit := uint(back.Uint64()*8 + 7)
 		num := stack.pop()
@@ -186,6 +172,7 @@ func opSignExtend(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stac
 	}
 
 	evm.interpreter.intPool.put(back)
+	evm.inspectArithmetic(SIGNEXTEND, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
@@ -197,6 +184,7 @@ func opNot(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stac
 
 func opLt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	if x.Cmp(y) < 0 {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	} else {
@@ -204,11 +192,13 @@ func opLt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack
 	}
 
 	evm.interpreter.intPool.put(x, y)
+	evm.inspectComparison(LT, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opGt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	if x.Cmp(y) > 0 {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	} else {
@@ -216,11 +206,14 @@ func opGt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack
 	}
 
 	evm.interpreter.intPool.put(x, y)
+	evm.inspectComparison(GT, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSlt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	x, y := math.S256(stack.pop()), math.S256(stack.pop())
+	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
+	x, y = math.S256(x), math.S256(y)
 	if x.Cmp(math.S256(y)) < 0 {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	} else {
@@ -228,11 +221,14 @@ func opSlt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stac
 	}
 
 	evm.interpreter.intPool.put(x, y)
+	evm.inspectComparison(SLT, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opSgt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	x, y := math.S256(stack.pop()), math.S256(stack.pop())
+	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
+	x, y = math.S256(x), math.S256(y)
 	if x.Cmp(y) > 0 {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	} else {
@@ -240,11 +236,13 @@ func opSgt(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stac
 	}
 
 	evm.interpreter.intPool.put(x, y)
+	evm.inspectComparison(SGT, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opEq(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	if x.Cmp(y) == 0 {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	} else {
@@ -252,6 +250,7 @@ func opEq(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack
 	}
 
 	evm.interpreter.intPool.put(x, y)
+	evm.inspectComparison(EQ, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
@@ -269,9 +268,11 @@ func opIszero(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 
 func opAnd(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y := stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y)
 	stack.push(x.And(x, y))
 
 	evm.interpreter.intPool.put(y)
+	evm.inspectArithmetic(AND, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
@@ -305,6 +306,7 @@ func opByte(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 
 func opAddmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y, z := stack.pop(), stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y, z)
 	if z.Cmp(bigZero) > 0 {
 		add := x.Add(x, y)
 		add.Mod(add, z)
@@ -314,11 +316,13 @@ func opAddmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 	}
 
 	evm.interpreter.intPool.put(y, z)
+	evm.inspectArithmetic(ADDMOD, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
 func opMulmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	x, y, z := stack.pop(), stack.pop(), stack.pop()
+	args := evm.captureArgs(x, y, z)
 	if z.Cmp(bigZero) > 0 {
 		mul := x.Mul(x, y)
 		mul.Mod(mul, z)
@@ -328,6 +332,7 @@ func opMulmod(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 	}
 
 	evm.interpreter.intPool.put(y, z)
+	evm.inspectArithmetic(MULMOD, *pc, contract, args, stack.peek())
 	return nil, nil
 }
 
@@ -370,7 +375,7 @@ func opCaller(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 }
 
 func opCallValue(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	stack.push(evm.interpreter.intPool.get().Set(contract.value))
+	stack.push(evm.interpreter.intPool.get().Set(contract.Value()))
 	return nil, nil
 }
 
@@ -539,10 +544,12 @@ func opSload(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *St
 	loc := common.BigToHash(stack.pop())
 	val := evm.StateDB.GetState(contract.Address(), loc).Big()
 	stack.push(val)
-	locval := make([]interface{}, 2)
-	locval[0] = loc
-	locval[1] = val
-	evm.Callback(locval)
+	if len(evm.inspectors) > 0 {
+		ctx := evm.opContext(SLOAD, *pc, contract)
+		for _, i := range evm.inspectors {
+			i.StorageRead(ctx, loc, val)
+		}
+	}
 	return nil, nil
 }
 
@@ -550,11 +557,13 @@ func opSstore(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 	loc := common.BigToHash(stack.pop())
 	val := stack.pop()
 	evm.StateDB.SetState(contract.Address(), loc, common.BigToHash(val))
+	if len(evm.inspectors) > 0 {
+		ctx := evm.opContext(SSTORE, *pc, contract)
+		for _, i := range evm.inspectors {
+			i.StorageWrite(ctx, loc, val)
+		}
+	}
 	evm.interpreter.intPool.put(val)
-	locval := make([]interface{}, 2)
-	locval[0] = loc
-	locval[1] = val
-	evm.Callback(locval)
 	return nil, nil
 }
 
@@ -565,6 +574,12 @@ func opJump(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 		nop := contract.GetOp(pos.Uint64())
 		return nil, fmt.Errorf("invalid jump destination (%v) %v", nop, pos)
 	}
+	if len(evm.inspectors) > 0 {
+		ctx := evm.opContext(JUMP, *pc, contract)
+		for _, i := range evm.inspectors {
+			i.Jump(ctx, pos.Uint64(), true)
+		}
+	}
 	*pc = pos.Uint64()
 
 	evm.interpreter.intPool.put(pos)
@@ -578,6 +593,14 @@ func opJumpi(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *St
 			nop := contract.GetOp(pos.Uint64())
 			return nil, fmt.Errorf("invalid jump destination (%v) %v", nop, pos)
 		}
+	}
+	if len(evm.inspectors) > 0 {
+		ctx := evm.opContext(JUMPI, *pc, contract)
+		for _, i := range evm.inspectors {
+			i.Jump(ctx, pos.Uint64(), cond.Sign() != 0)
+		}
+	}
+	if cond.Sign() != 0 {
 		*pc = pos.Uint64()
 	} else {
 		*pc++
@@ -618,7 +641,22 @@ func opCreate(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 	}
 
 	contract.UseGas(gas)
+	var ctx OpContext
+	if len(evm.inspectors) > 0 {
+		ctx = evm.opContext(CREATE, *pc, contract)
+		evm.inspectCallEnter(ctx, crypto.CreateAddress(contract.Address(), evm.StateDB.GetNonce(contract.Address())), input, gas, value)
+	}
 	res, addr, returnGas, suberr := evm.Create(contract, input, gas, value)
+	if len(evm.inspectors) > 0 {
+		evm.inspectCallExit(ctx, res, suberr)
+		created := addr
+		if suberr != nil {
+			created = common.Address{}
+		}
+		for _, i := range evm.inspectors {
+			i.Create(ctx, created, input, value, suberr)
+		}
+	}
 	// Push item on the stack based on the returned error. If the ruleset is
 	// homestead we must check for CodeStoreOutOfGasError (homestead only
 	// rule) and treat as an error, if the ruleset is frontier we must
@@ -653,7 +691,15 @@ func opCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 	if value.Sign() != 0 {
 		gas += params.CallStipend
 	}
+	var ctx OpContext
+	if len(evm.inspectors) > 0 {
+		ctx = evm.opContext(CALL, *pc, contract)
+		evm.inspectCallEnter(ctx, toAddr, args, gas, value)
+	}
 	ret, returnGas, err := evm.Call(contract, toAddr, args, gas, value)
+	if len(evm.inspectors) > 0 {
+		evm.inspectCallExit(ctx, ret, err)
+	}
 	if err != nil {
 		stack.push(new(big.Int))
 	} else {
@@ -682,7 +728,15 @@ func opCallCode(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack
 	if value.Sign() != 0 {
 		gas += params.CallStipend
 	}
+	var ctx OpContext
+	if len(evm.inspectors) > 0 {
+		ctx = evm.opContext(CALLCODE, *pc, contract)
+		evm.inspectCallEnter(ctx, toAddr, args, gas, value)
+	}
 	ret, returnGas, err := evm.CallCode(contract, toAddr, args, gas, value)
+	if len(evm.inspectors) > 0 {
+		evm.inspectCallExit(ctx, ret, err)
+	}
 	if err != nil {
 		stack.push(new(big.Int))
 	} else {
@@ -707,7 +761,15 @@ func opDelegateCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, st
 	// Get arguments from the memory.
 	args := memory.Get(inOffset.Int64(), inSize.Int64())
 
+	var ctx OpContext
+	if len(evm.inspectors) > 0 {
+		ctx = evm.opContext(DELEGATECALL, *pc, contract)
+		evm.inspectCallEnter(ctx, toAddr, args, gas, contract.Value())
+	}
 	ret, returnGas, err := evm.DelegateCall(contract, toAddr, args, gas)
+	if len(evm.inspectors) > 0 {
+		evm.inspectCallExit(ctx, ret, err)
+	}
 	if err != nil {
 		stack.push(new(big.Int))
 	} else {
@@ -732,7 +794,15 @@ func opStaticCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stac
 	// Get arguments from the memory.
 	args := memory.Get(inOffset.Int64(), inSize.Int64())
 
+	var ctx OpContext
+	if len(evm.inspectors) > 0 {
+		ctx = evm.opContext(STATICCALL, *pc, contract)
+		evm.inspectCallEnter(ctx, toAddr, args, gas, nil)
+	}
 	ret, returnGas, err := evm.StaticCall(contract, toAddr, args, gas)
+	if len(evm.inspectors) > 0 {
+		evm.inspectCallExit(ctx, ret, err)
+	}
 	if err != nil {
 		stack.push(new(big.Int))
 	} else {
@@ -769,9 +839,16 @@ func opStop(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 
 func opSuicide(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	balance := evm.StateDB.GetBalance(contract.Address())
-	evm.StateDB.AddBalance(common.BigToAddress(stack.pop()), balance)
+	beneficiary := common.BigToAddress(stack.pop())
+	evm.StateDB.AddBalance(beneficiary, balance)
 
 	evm.StateDB.Suicide(contract.Address())
+	if len(evm.inspectors) > 0 {
+		ctx := evm.opContext(SELFDESTRUCT, *pc, contract)
+		for _, i := range evm.inspectors {
+			i.Selfdestruct(ctx, beneficiary, balance)
+		}
+	}
 	return nil, nil
 }
 
@@ -795,6 +872,12 @@ func makeLog(size int) executionFunc {
 			// core/state doesn't know the current block number.
 			BlockNumber: evm.BlockNumber.Uint64(),
 		})
+		if len(evm.inspectors) > 0 {
+			ctx := evm.opContext(LOG0+OpCode(size), *pc, contract)
+			for _, i := range evm.inspectors {
+				i.Log(ctx, topics, d)
+			}
+		}
 
 		evm.interpreter.intPool.put(mStart, mSize)
 		return nil, nil
@@ -214,6 +214,13 @@ func (in *Interpreter) Run(contract *Contract, input []byte) (ret []byte, err er
 			logged = true
 		}
 
+		if len(in.evm.inspectors) > 0 {
+			ctx := in.evm.opContext(op, pc, contract)
+			for _, i := range in.evm.inspectors {
+				i.Step(ctx, stack.Data())
+			}
+		}
+
 		// execute the operation
 		res, err := operation.execute(&pc, in.evm, contract, mem, stack)
 		// verifyPool is a build flag. Pool verification makes sure the integrity
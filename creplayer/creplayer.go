@@ -8,6 +8,7 @@ import (
 	"minievm/core"
 	"minievm/core/state"
 	"minievm/core/vm"
+	"minievm/ethdb"
 	"minievm/params"
 	"time"
 
@@ -21,7 +22,13 @@ type CReplayer struct {
 	evm             *vm.EVM
 	caddr           common.Address
 	rpcclient       *rpc.Client
+	cache           ethdb.Database
+	backend         state.Backend
 	txs             []rpcTransaction
+
+	// AutoFund, if set, is the balance the replayed senders start with
+	// instead of having to be funded by AddAllMoney.
+	AutoFund *big.Int
 }
 
 type rpcTransaction struct {
@@ -67,7 +74,7 @@ func (cr *CReplayer) DeployContract() {
 
 	cr.ContractCreater = common.HexToAddress("0x802df0c73eb17e540b39f1ae73c13dcea5a1caaa")
 
-	cr.state = state.New()
+	cr.state = state.NewWithConfig(state.Config{AutoFund: cr.AutoFund})
 	cr.state.AddBalance(cr.ContractCreater, big.NewInt(int64(100)))
 	cr.state.SetNonce(cr.ContractCreater, uint64(0))
 
@@ -91,12 +98,54 @@ func (cr *CReplayer) DeployContract() {
 	log.Printf("0x%02x", caddr)
 }
 
+// ForkContract replays against the deployed contract at addr instead of the
+// built-in bytecode. Accounts and storage are fetched lazily from the node as
+// of block and cached in the LevelDB at cachepath.
+func (cr *CReplayer) ForkContract(addr string, block uint64, cachepath string) error {
+	cache, err := ethdb.NewLDBDatabase(cachepath, 16, 16)
+	if err != nil {
+		return err
+	}
+	cr.cache = cache
+	cr.backend = state.NewRPCBackend(cr.rpcclient, block, cache)
+	cr.state = state.NewWithConfig(state.Config{AutoFund: cr.AutoFund, Backend: cr.backend})
+
+	cr.context = &vm.Context{
+		Transfer:    core.Transfer,
+		CanTransfer: core.CanTransfer,
+		BlockNumber: new(big.Int).SetUint64(block + 1),
+		Time:        big.NewInt(time.Now().Unix()),
+		GetHash:     func(in uint64) common.Hash { return common.BigToHash(big.NewInt(int64(in))) },
+		GasPrice:    big.NewInt(100),
+		Difficulty:  big.NewInt(100),
+	}
+	cr.evm = vm.NewEVM(*cr.context, cr.state, params.MainnetChainConfig, vm.Config{EnableJit: false, ForceJit: false, Debug: false, NoRecursion: false})
+	cr.caddr = common.HexToAddress(addr)
+	if !cr.state.Exist(cr.caddr) {
+		if err := cr.state.Error(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no contract at %s in block %d", addr, block)
+	}
+	return nil
+}
+
+// Close releases the cache ForkContract opened
+func (cr *CReplayer) Close() {
+	if cr.cache != nil {
+		cr.cache.Close()
+		cr.cache = nil
+	}
+}
+
 func (cr *CReplayer) Call(calleraddress, calldata, gaslimit, value string) ([]byte, uint64, error) {
 	gas := new(big.Int)
 	gas.SetString(gaslimit, 0)
 	val := new(big.Int)
 	val.SetString(value, 0)
-	return cr.evm.Call(vm.AccountRef(common.HexToAddress(calleraddress)), cr.caddr, common.Hex2Bytes(calldata[2:]), gas.Uint64(), val)
+	ret, leftOverGas, err := cr.evm.Call(vm.AccountRef(common.HexToAddress(calleraddress)), cr.caddr, common.Hex2Bytes(calldata[2:]), gas.Uint64(), val)
+	cr.state.Finalise(cr.evm.ChainConfig().IsEIP158(cr.evm.BlockNumber))
+	return ret, leftOverGas, err
 }
 
 func (cr *CReplayer) TransactionExecuter() {
@@ -105,10 +154,15 @@ func (cr *CReplayer) TransactionExecuter() {
 	for _, tx := range cr.txs {
 		log.Printf("%s %s %s %s %s\n", tx.Hash, tx.From, tx.Input, tx.Gas, tx.Value)
 		cr.Call(tx.From, tx.Input, tx.Gas, tx.Value)
+		if err := cr.state.Error(); err != nil {
+			log.Print(err)
+			return
+		}
 		ret, _, _ := cr.Call(tx.From, "0x4b750334", tx.Gas, "0x00")
 		log.Printf("\nsell price: [%02x]", ret)
 		ret, _, _ = cr.Call(tx.From, "0x8620410b", tx.Gas, "0x00")
 		log.Printf("\n buy price: [%02x]\n\n", ret)
+		log.Printf("replayed state root: %x\n", cr.StateRoot())
 
 		// cr.state.Print()
 	}
@@ -149,3 +203,37 @@ func (cr *CReplayer) AddAllMoney() {
 		cr.state.AddBalance(common.HexToAddress(address), ether.Mul(ether, big.NewInt(1000)))
 	}
 }
+
+// StateRoot returns the root hash of the replayed state. Forked with
+// ForkContract, the state only holds the accounts the replay touched, so the
+// root identifies the replayed state but can't match the block's state root.
+func (cr *CReplayer) StateRoot() common.Hash {
+	return cr.state.IntermediateRoot(cr.evm.ChainConfig().IsEIP158(cr.context.BlockNumber))
+}
+
+// Checkpoint saves the replayed state into the LevelDB at path and returns
+// the id to resume from
+func (cr *CReplayer) Checkpoint(path string) (common.Hash, error) {
+	db, err := ethdb.NewLDBDatabase(path, 16, 16)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer db.Close()
+	return cr.state.Save(db)
+}
+
+// Resume continues replaying on top of the state Checkpoint saved under id
+func (cr *CReplayer) Resume(path string, id common.Hash) error {
+	db, err := ethdb.NewLDBDatabase(path, 16, 16)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	st, err := state.LoadWithConfig(id, db, state.Config{AutoFund: cr.AutoFund, Backend: cr.backend})
+	if err != nil {
+		return err
+	}
+	cr.state = st
+	cr.evm.StateDB = st
+	return nil
+}
@@ -1,13 +1,40 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"minievm/common"
 	"minievm/creplayer"
+	"os"
 )
 
 func main4() {
+	flags := flag.NewFlagSet("creplayer", flag.ExitOnError)
+	savePath := flags.String("save", "", "LevelDB path to checkpoint the replayed state into, its id is logged")
+	loadPath := flags.String("load", "", "LevelDB path to resume replaying from, see -save")
+	checkpointID := flags.String("checkpoint", "", "id of the checkpoint to resume from with -load")
+	forkAddr := flags.String("at", "", "address of the deployed contract to replay against instead of the built-in one")
+	forkBlock := flags.Uint64("block", 0, "block number to fork chain state at, with -at")
+	cachePath := flags.String("cache", "./fork_cache", "cache path for forked chain state")
+	flags.Parse(os.Args[1:])
+
 	cr := &creplayer.CReplayer{}
 	cr.Init()
 	cr.DeployContract()
+	if *forkAddr != "" {
+		if err := cr.ForkContract(*forkAddr, *forkBlock, *cachePath); err != nil {
+			log.Fatal(err)
+		}
+		defer cr.Close()
+	}
+	if *loadPath != "" {
+		if *checkpointID == "" {
+			log.Fatal("-load needs the -checkpoint id logged by -save")
+		}
+		if err := cr.Resume(*loadPath, common.HexToHash(*checkpointID)); err != nil {
+			log.Fatal(err)
+		}
+	}
 	cr.FetchAllTxs(6176235, 6182468)
 	// txs := cr.FetchAllTxs(6182468, 6182468, "0xca6378fcdf24ef34b4062dda9f1862ea59bafd4d")
 	// for _, tx := range txs {
@@ -15,4 +42,11 @@ func main4() {
 	// }
 	cr.AddAllMoney()
 	cr.TransactionExecuter()
+	if *savePath != "" {
+		id, err := cr.Checkpoint(*savePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Checkpoint: %x\n", id)
+	}
 }
@@ -0,0 +1,94 @@
+package detectors
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+//lockedWriter serializes the writes of concurrent workers and keeps the keys
+//of the findings they wrote, so each finding is reported once
+type lockedWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	found map[string]bool
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+//claim marks the finding identified by key as found and reports whether no
+//worker found it before
+func (lw *lockedWriter) claim(key string) bool {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.found[key] {
+		return false
+	}
+	lw.found[key] = true
+	return true
+}
+
+//Campaign fuzzes one contract on several workers. Each worker owns its
+//ContractUtils, EVM and StateDB; they share corpus and coverage, split the
+//storage rounds and sequences between them and write to a single report.
+type Campaign struct {
+	Workers []*FuzzInt
+	logpath string
+}
+
+//NewCampaign creates n workers for the contracts at contractpath, worker i is
+//seeded with seed+i
+func NewCampaign(solcpath, contractpath, logpath string, seed int64, n int) *Campaign {
+	return newCampaign(func(seed int64) *FuzzInt {
+		return NewContractFuzzer(solcpath, contractpath, logpath, false, seed)
+	}, seed, n)
+}
+
+//newCampaign creates n workers with newWorker
+func newCampaign(newWorker func(seed int64) *FuzzInt, seed int64, n int) *Campaign {
+	first := newWorker(seed)
+	c := &Campaign{Workers: []*FuzzInt{first}, logpath: first.logpath}
+	for i := 1; i < n; i++ {
+		fi := newWorker(seed + int64(i))
+		fi.corpus = first.corpus
+		fi.coverage.Share(first.coverage)
+		c.Workers = append(c.Workers, fi)
+	}
+	for i, fi := range c.Workers {
+		fi.shard, fi.shards = i, n
+	}
+	return c
+}
+
+//Run fuzzes single calls on all workers, then seqrounds sequences split between them
+func (c *Campaign) Run(seqrounds int) error {
+	f, err := os.Create(c.logpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report := &lockedWriter{w: f, found: make(map[string]bool)}
+	var wg sync.WaitGroup
+	for i, fi := range c.Workers {
+		fi.report = report
+		rounds := seqrounds / len(c.Workers)
+		if i < seqrounds%len(c.Workers) {
+			rounds++
+		}
+		wg.Add(1)
+		go func(fi *FuzzInt, rounds int) {
+			defer wg.Done()
+			fi.FuzzContracts()
+			if rounds > 0 {
+				fi.FuzzSequences(rounds)
+			}
+		}(fi, rounds)
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,51 @@
+package detectors
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCampaign(t *testing.T) {
+	out, logpath := armed(t), t.TempDir()
+	c := newCampaign(func(seed int64) *FuzzInt {
+		return newFuzzer(newCompiledContract(out), "Test.sol", logpath, false, seed)
+	}, 7, 3)
+	for i, fi := range c.Workers {
+		if fi.shard != i || fi.shards != 3 || fi.seed != 7+int64(i) {
+			t.Fatalf("worker %d: shard %d of %d, seed %d", i, fi.shard, fi.shards, fi.seed)
+		}
+		if fi.corpus != c.Workers[0].corpus || fi.coverage.seen != c.Workers[0].coverage.seen {
+			t.Fatalf("worker %d doesn't share corpus and coverage", i)
+		}
+		if i > 0 && fi.contracts.evm == c.Workers[0].contracts.evm {
+			t.Fatalf("worker %d shares the evm", i)
+		}
+	}
+	if err := c.Run(60); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ioutil.ReadFile(c.logpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), "Overflow") {
+		t.Fatalf("no finding reported:\n%s", report)
+	}
+	lines := strings.Split(string(report), "\n")
+	seen := make(map[string]bool)
+	for i := 1; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "Seed: ") {
+			continue
+		}
+		// the arguments differ between cases of the same finding
+		finding := strings.TrimPrefix(strings.SplitN(lines[i-1], " args ", 2)[0], "Unreproduced ")
+		if seen[finding] {
+			t.Fatalf("%s reported twice:\n%s", finding, report)
+		}
+		seen[finding] = true
+	}
+	if c.Workers[0].coverage.Edges() == 0 {
+		t.Fatal("no coverage shared")
+	}
+}
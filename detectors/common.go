@@ -2,28 +2,23 @@ package detectors
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"minievm/accounts/abi"
 	"minievm/common"
+	"minievm/common/math"
 	"os"
 	"os/exec"
 	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 
 	cartesian "github.com/schwarmco/go-cartesian-product"
 )
 
-const (
-	keyPrefix = "Nyanpass. Overflow detected: "
-	add       = keyPrefix + "Add"
-	sub       = keyPrefix + "Sub"
-	mul       = keyPrefix + "Mul"
-	div       = keyPrefix + "Div"
-)
-
 type Contract struct {
 	Abi string `json:"abi"`
 	Bin string `json:"bin"`
@@ -130,6 +125,7 @@ const (
 	HashTy
 	FixedPointTy
 	FunctionTy
+	TupleTy
 )
 
 func GenerateInputsByABI(abi abi.ABI, function string) (c chan []interface{}, types []reflect.Type, total int) {
@@ -155,56 +151,100 @@ func GenerateInputsByABI(abi abi.ABI, function string) (c chan []interface{}, ty
 	}
 	for _, x := range abi.Methods[function].Inputs {
 		types = append(types, x.Type.Type)
-		switch x.Type.T {
-		case SliceTy:
-			{
-				switch x.Type.Elem.T {
-				case AddressTy:
-					{
-						addresses := GenerateAddresses()
-						s := make([]interface{}, len(addresses))
-						for i, v := range addresses {
-							s[i] = v
-						}
-						d = append(d, s)
-						total *= len(s)
-					}
-				}
+		s := GenerateValuesByType(x.Type)
+		d = append(d, s)
+		total *= len(s)
+	}
+	c = cartesian.Iter(d...)
+	return
+}
+
+//GenerateValuesByType returns boundary values of t as the Go values abi.Pack takes:
+//slices are prefixes of the element values, fixed arrays repeat one of them and
+//tuples take the i-th value of every field
+func GenerateValuesByType(t abi.Type) (res []interface{}) {
+	switch t.T {
+	case UintTy, IntTy:
+		var numbers []*big.Int
+		if t.T == UintTy {
+			numbers = GenerateIntBySize(t.Size)
+		} else {
+			max := new(big.Int).Sub(math.BigPow(2, int64(t.Size-1)), common.Big1)
+			min := new(big.Int).Neg(math.BigPow(2, int64(t.Size-1)))
+			numbers = []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(-1), max, new(big.Int).Sub(max, common.Big1), min, new(big.Int).Add(min, common.Big1)}
+		}
+		for _, n := range numbers {
+			if t.Kind == reflect.Ptr {
+				res = append(res, n)
+				continue
 			}
-		case UintTy:
-			{
-				numbers := GenerateIntBySize(x.Type.Size)
-				s := make([]interface{}, len(numbers))
-				for i, v := range numbers {
-					s[i] = v
-				}
-				d = append(d, s)
-				total *= len(s)
+			// uint8..uint64 and int8..int64 are packed from native Go integers
+			v := reflect.New(t.Type).Elem()
+			switch {
+			case t.T == UintTy && n.BitLen() <= t.Size:
+				v.SetUint(n.Uint64())
+			case t.T == IntTy:
+				v.SetInt(n.Int64())
+			default:
+				continue
 			}
-		case AddressTy:
-			{
-				// addresses := []common.Address{common.BytesToAddress([]byte("Attacker001")),
-				// 	common.BytesToAddress([]byte("Attacker002")),
-				// 	common.BytesToAddress([]byte("Attacker003"))}
-				// s := make([]interface{}, len(addresses))
-				// for i, v := range addresses {
-				// 	s[i] = v
-				// }
-				// d = append(d, s)
-				// total *= len(s)
-				addresses := GenerateAddress()
-				s := make([]interface{}, len(addresses))
-				for i, v := range addresses {
-					s[i] = v
+			res = append(res, v.Interface())
+		}
+	case BoolTy:
+		res = []interface{}{false, true}
+	case AddressTy:
+		for _, address := range GenerateAddress() {
+			res = append(res, address)
+		}
+	case StringTy:
+		res = []interface{}{"", "a", strings.Repeat("z", 33)}
+	case BytesTy:
+		res = []interface{}{[]byte{}, []byte{1}, bytes.Repeat([]byte{0xff}, 33)}
+	case FixedBytesTy:
+		full := reflect.New(t.Type).Elem()
+		for i := 0; i < t.Size; i++ {
+			full.Index(i).SetUint(0xff)
+		}
+		res = []interface{}{reflect.Zero(t.Type).Interface(), full.Interface()}
+	case SliceTy:
+		elems := GenerateValuesByType(*t.Elem)
+		for n := 0; n < len(elems) && n < 10; n++ {
+			v := reflect.MakeSlice(t.Type, n, n)
+			for i := 0; i < n; i++ {
+				v.Index(i).Set(reflect.ValueOf(elems[i]))
+			}
+			res = append(res, v.Interface())
+		}
+	case ArrayTy:
+		for _, elem := range GenerateValuesByType(*t.Elem) {
+			v := reflect.New(t.Type).Elem()
+			for i := 0; i < t.Size; i++ {
+				v.Index(i).Set(reflect.ValueOf(elem))
+			}
+			res = append(res, v.Interface())
+		}
+	case TupleTy:
+		fields := make([][]interface{}, len(t.TupleElems))
+		n := 1
+		for i, elem := range t.TupleElems {
+			fields[i] = GenerateValuesByType(*elem)
+			if len(fields[i]) > n {
+				n = len(fields[i])
+			}
+		}
+		for j := 0; j < n; j++ {
+			v := reflect.New(t.Type).Elem()
+			for i, values := range fields {
+				if len(values) > 0 {
+					v.Field(i).Set(reflect.ValueOf(values[j%len(values)]))
 				}
-				d = append(d, s)
-				total *= len(s)
 			}
-		default:
-			break
+			res = append(res, v.Interface())
 		}
+	default:
+		// function, hash and fixed point values are passed as is
+		res = []interface{}{reflect.Zero(t.Type).Interface()}
 	}
-	c = cartesian.Iter(d...)
 	return
 }
 
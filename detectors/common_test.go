@@ -0,0 +1,38 @@
+package detectors
+
+import (
+	"minievm/accounts/abi"
+	"strings"
+	"testing"
+)
+
+func TestGenerateInputsByABI(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"ints","inputs":[{"name":"a","type":"uint8"},{"name":"b","type":"int16"},{"name":"c","type":"int256"}]},
+		{"type":"function","name":"dynamic","inputs":[{"name":"s","type":"string"},{"name":"b","type":"bytes"},{"name":"to","type":"address[]"}]},
+		{"type":"function","name":"fixed","inputs":[{"name":"f","type":"bytes4"},{"name":"p","type":"uint256[2]"},{"name":"ok","type":"bool"}]},
+		{"type":"function","name":"tuples","inputs":[{"name":"t","type":"tuple[]","components":[{"name":"a","type":"uint64"},{"name":"s","type":"string"}]}]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, method := range parsed.Methods {
+		c, types, total := GenerateInputsByABI(parsed, name)
+		if c == nil || total == 0 || len(types) != len(method.Inputs) {
+			t.Fatalf("%s: no inputs generated", name)
+		}
+		n := 0
+		for product := range c {
+			n++
+			if _, err := parsed.Pack(name, product...); err != nil {
+				t.Fatalf("%s: %v can't be packed: %v", name, product, err)
+			}
+		}
+		if n != total {
+			t.Errorf("%s: generated %d inputs, want %d", name, n, total)
+		}
+	}
+	if c, _, _ := GenerateInputsByABI(parsed, "missing"); c != nil {
+		t.Error("inputs generated for a missing method")
+	}
+}
@@ -1,6 +1,7 @@
 package detectors
 
 import (
+	"fmt"
 	"log"
 	"math/big"
 	"minievm/accounts/abi"
@@ -8,19 +9,25 @@ import (
 	"minievm/core"
 	"minievm/core/state"
 	"minievm/core/vm"
+	"minievm/ethdb"
 	"minievm/params"
+	"sort"
 	"strings"
-	"time"
-
-	"github.com/jinzhu/copier"
 )
 
 const (
 	contractcreator  = "One key to rule them all"
 	contractattacker = "Contract Attacker...maybe"
 	emptyaddress     = "Empty Address"
+	// blocktime is the timestamp of the Byzantium fork block, fixed so runs can be replayed
+	blocktime = 1508131331
 )
 
+//AutoFund is the balance every account the fuzzed contracts see for the first
+//time starts with (senders, counterparties, addresses passed as arguments), nil
+//leaves them empty
+var AutoFund *big.Int
+
 type ContractUtils struct {
 	ContractCreater, ContractAttacker common.Address
 	state                             *state.StateDB
@@ -30,7 +37,16 @@ type ContractUtils struct {
 	Contracts                         map[string]SimpleContract
 	MainContract                      SimpleContract
 	SkippedVars                       []string
-	stateBackup                       *state.StateDB
+	fork                              *state.StateDB
+	backend                           state.Backend // set by AttachRemote
+}
+
+//MappingEntry is a mapping(key => value) element found in contract storage
+type MappingEntry struct {
+	Slot  common.Hash // storage location, keccak256(Key . Base)
+	Base  common.Hash // slot the mapping is declared at
+	Key   common.Hash // mapping key, left padded to 32 bytes
+	Value common.Hash
 }
 
 type SimpleContract struct {
@@ -47,24 +63,46 @@ func (contract SimpleContract) Call(calleraddress common.Address, calldata []byt
 	return
 }
 
-//EVMCallback injects into evm instruction
-func EVMCallback(args []interface{}) {
-	callername := GetCallerName(2)
-	log.Print(callername)
-	loc := args[0].(common.Hash)
-	val := args[1].(*big.Int)
-	log.Printf("%02X, %02X", loc, val)
+//StorageLogger logs every storage access of the evm
+type StorageLogger struct {
+	vm.NoopInspector
+}
+
+func (StorageLogger) StorageRead(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	log.Printf("SLOAD %02X, %02X", key, value)
+}
+
+func (StorageLogger) StorageWrite(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	log.Printf("SSTORE %02X, %02X", key, value)
 }
 
-//NULLCallback do nothing
-func NULLCallback(args []interface{}) {
+//firstSload records the location of the first SLOAD
+type firstSload struct {
+	vm.NoopInspector
+	loc     common.Hash
+	touched bool
+}
 
+func (fs *firstSload) StorageRead(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	if !fs.touched {
+		fs.loc = key
+		fs.touched = true
+	}
 }
 
 //NewContract create a new contract and deploy to storage
 func NewContract(solcpath, path string) *ContractUtils {
+	solcout, err := CompileContract(solcpath, path)
+	if err != nil {
+		log.Print("Compile Contract err...", err, path)
+	}
+	return newCompiledContract(solcout)
+}
+
+//newCompiledContract deploys the contracts of solcout
+func newCompiledContract(solcout *SolcOutput) *ContractUtils {
 	su := &ContractUtils{}
-	su.DeployContracts(solcpath, path)
+	su.deploy(solcout)
 	su.SetSkippedVars([]string{})
 	return su
 }
@@ -75,11 +113,15 @@ func (cu *ContractUtils) DeployContracts(solcpath, path string) {
 	if err != nil {
 		log.Print("Compile Contract err...", err, path)
 	}
+	cu.deploy(solcout)
+}
 
+//deploy deploys the contracts of solcout on a fresh state
+func (cu *ContractUtils) deploy(solcout *SolcOutput) {
 	cu.ContractCreater = common.StringToAddress(contractcreator)
 	cu.ContractAttacker = common.StringToAddress(contractattacker)
 
-	cu.state = state.New()
+	cu.state = state.NewWithConfig(state.Config{AutoFund: AutoFund})
 	cu.state.AddBalance(cu.ContractCreater, big.NewInt(int64(100)))
 	cu.state.AddBalance(cu.ContractAttacker, big.NewInt(int64(100)))
 	cu.state.SetNonce(cu.ContractCreater, uint64(20))
@@ -89,17 +131,25 @@ func (cu *ContractUtils) DeployContracts(solcpath, path string) {
 		Transfer:    core.Transfer,
 		CanTransfer: core.CanTransfer,
 		BlockNumber: big.NewInt(4370001),
-		Time:        big.NewInt(time.Now().Unix()),
+		Time:        big.NewInt(blocktime),
 		GetHash:     func(in uint64) common.Hash { return common.BigToHash(big.NewInt(int64(in))) },
 		GasPrice:    big.NewInt(100),
 		Difficulty:  big.NewInt(100),
 	}
 
-	cu.evm = vm.NewEVM(*cu.context, cu.state, params.MainnetChainConfig, vm.Config{EnableJit: false, ForceJit: false, Debug: false, NoRecursion: true})
+	cu.evm = vm.NewEVM(*cu.context, cu.state, params.MainnetChainConfig, vm.Config{EnableJit: false, ForceJit: false, Debug: false, NoRecursion: true, EnablePreimageRecording: true})
+
+	// deploy in name order so addresses don't change between runs
+	names := make([]string, 0, len(solcout.Contracts))
+	for name := range solcout.Contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
 	methodsandeventscount := 0
 	cu.Contracts = make(map[string]SimpleContract)
-	for name, contract := range solcout.Contracts {
+	for _, name := range names {
+		contract := solcout.Contracts[name]
 		// log.Print("name:", name)
 		code := common.Hex2Bytes(contract.Bin)
 		_, caddr, _, err := cu.evm.Create(vm.AccountRef(cu.ContractCreater), code, uint64(100000000000), big.NewInt(0))
@@ -121,18 +171,40 @@ func (cu *ContractUtils) DeployContracts(solcpath, path string) {
 			methodsandeventscount = len(abidecode.Methods) + len(abidecode.Events)
 		}
 	}
+	cu.Finalise()
 	// log.Print("Main Contract:", cu.MainContract.Name)
 }
 
-func (cu *ContractUtils) BackupStates() {
-	cu.stateBackup = state.New()
-	copier.Copy(cu.stateBackup, cu.state)
-	// cu.stateBackup.Print()
+//ForkStates runs the following calls on a copy-on-write fork of the deployed state
+func (cu *ContractUtils) ForkStates() {
+	cu.fork = cu.state.Fork()
+	cu.evm.StateDB = cu.fork
+}
+
+//DiscardStates drops everything written since ForkStates
+func (cu *ContractUtils) DiscardStates() {
+	cu.fork.Discard()
+	cu.fork = nil
+	cu.evm.StateDB = cu.state
 }
 
-func (cu *ContractUtils) RestoreStates() {
-	copier.Copy(cu.state, cu.stateBackup)
-	// cu.state.Print()
+//CurrentState returns the fork if one is active, the deployed state otherwise
+func (cu *ContractUtils) CurrentState() *state.StateDB {
+	if cu.fork != nil {
+		return cu.fork
+	}
+	return cu.state
+}
+
+//Finalise ends a top level call on the current state, deleting the accounts
+//that suicided (and the empty ones it touched, EIP158)
+func (cu *ContractUtils) Finalise() {
+	cu.CurrentState().Finalise(cu.evm.ChainConfig().IsEIP158(cu.evm.BlockNumber))
+}
+
+//AddInspector registers an inspector on the contracts' evm
+func (cu *ContractUtils) AddInspector(i vm.Inspector) {
+	cu.evm.AddInspector(i)
 }
 
 //SetSkippedVars skips vars we don't care
@@ -161,11 +233,11 @@ func (cu *ContractUtils) FilterOutConstantNumABI() map[string]abi.Method {
 }
 
 func (cu *ContractUtils) SetStorage(loc common.Hash, value *big.Int) {
-	cu.state.SetState(cu.MainContract.Address, loc, common.BytesToHash(abi.U256(value)))
+	cu.CurrentState().SetState(cu.MainContract.Address, loc, common.BytesToHash(abi.U256(value)))
 }
 
 func (cu *ContractUtils) GetStorage(loc common.Hash) common.Hash {
-	return cu.state.GetState(cu.MainContract.Address, loc)
+	return cu.CurrentState().GetState(cu.MainContract.Address, loc)
 }
 
 //Call evm.Call proxy
@@ -180,26 +252,34 @@ func (cu *ContractUtils) SimpleCall(calleraddress common.Address, contractaddr c
 	return
 }
 
+//Peek makes a SimpleCall the inspectors don't see and reverts what it changed,
+//for reading the state between the calls under test
+func (cu *ContractUtils) Peek(calleraddress common.Address, contractaddr common.Address, calldata []byte) (ret []byte, err error) {
+	st := cu.CurrentState()
+	snapshot := st.Snapshot()
+	defer st.RevertToSnapshot(snapshot)
+	inspectors := append([]vm.Inspector(nil), cu.evm.Inspectors()...)
+	for _, i := range inspectors {
+		cu.evm.RemoveInspector(i)
+	}
+	defer func() {
+		for _, i := range inspectors {
+			cu.evm.AddInspector(i)
+		}
+	}()
+	return cu.SimpleCall(calleraddress, contractaddr, calldata)
+}
+
 //TouchSload returns all constant loc in stateDB
 func (cu *ContractUtils) TouchSload(contractaddr common.Address, abiin abi.Method) (common.Hash, error) {
-	touched := false
-	prevCb := cu.evm.Callback
-	var storageLoc common.Hash
-	cu.evm.Callback = func(args []interface{}) {
-		if !touched {
-			callername := GetCallerName(2)
-			if callername == "minievm/core/vm.opSload" {
-				storageLoc = args[0].(common.Hash)
-				touched = true
-			}
-		}
-	}
+	fs := &firstSload{}
+	cu.evm.AddInspector(fs)
+	defer cu.evm.RemoveInspector(fs)
 	_, _, err := cu.evm.Call(vm.AccountRef(cu.ContractCreater), contractaddr, abiin.Id(), uint64(100000000000), big.NewInt(0))
 	if err != nil {
 		log.Print("EVM Call Error...", err)
 	}
-	cu.evm.Callback = prevCb
-	return storageLoc, err
+	return fs.loc, err
 }
 
 //GetStorageLoc returns {name:loc} pair
@@ -215,3 +295,88 @@ func (cu *ContractUtils) GetStorageLoc() map[string]common.Hash {
 	}
 	return storageLoc
 }
+
+//SaveStates checkpoints the deployed state into the LevelDB at path and returns
+//the id to load it back with
+func (cu *ContractUtils) SaveStates(path string) (common.Hash, error) {
+	db, err := ethdb.NewLDBDatabase(path, 16, 16)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer db.Close()
+	return cu.state.Save(db)
+}
+
+//LoadStates replaces the deployed state with the checkpoint SaveStates wrote
+//under id, slots it doesn't hold are still fetched after AttachRemote
+func (cu *ContractUtils) LoadStates(path string, id common.Hash) error {
+	db, err := ethdb.NewLDBDatabase(path, 16, 16)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	st, err := state.LoadWithConfig(id, db, state.Config{AutoFund: AutoFund, Backend: cu.backend})
+	if err != nil {
+		return err
+	}
+	cu.state = st
+	cu.fork = nil
+	cu.evm.StateDB = st
+	return nil
+}
+
+//AttachRemote fuzzes the contract deployed at addr on a real chain instead of the locally deployed main contract
+func (cu *ContractUtils) AttachRemote(backend state.Backend, addr common.Address) error {
+	st := state.NewWithConfig(state.Config{AutoFund: AutoFund, Backend: backend})
+	st.AddBalance(cu.ContractCreater, big.NewInt(int64(100)))
+	st.AddBalance(cu.ContractAttacker, big.NewInt(int64(100)))
+	if !st.Exist(addr) {
+		if err := st.Error(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no contract at %x", addr)
+	}
+	st.Finalise(cu.evm.ChainConfig().IsEIP158(cu.evm.BlockNumber))
+	cu.state = st
+	cu.fork = nil
+	cu.backend = backend
+	cu.evm.StateDB = st
+	cu.MainContract.Address = addr
+	cu.Contracts[cu.MainContract.Name] = cu.MainContract
+	return nil
+}
+
+//ApplyAlloc overrides the deployed state with the accounts in alloc
+func (cu *ContractUtils) ApplyAlloc(alloc core.GenesisAlloc) {
+	alloc.Apply(cu.state)
+	cu.Finalise()
+}
+
+//DumpAlloc returns the current state in genesis alloc format
+func (cu *ContractUtils) DumpAlloc() core.GenesisAlloc {
+	return core.DumpAlloc(cu.CurrentState())
+}
+
+//StateRoot returns the root hash of the current state, equal states share the same root. On a
+//remote fork it only covers the accounts fetched so far, not the chain's state
+func (cu *ContractUtils) StateRoot() common.Hash {
+	return cu.CurrentState().IntermediateRoot(true)
+}
+
+//GetTouchedMappingEntries returns the mapping elements written since ForkStates whose value differs from the deployed state, zeroed ones included
+func (cu *ContractUtils) GetTouchedMappingEntries(contractaddr common.Address) []MappingEntry {
+	var touched []MappingEntry
+	st := cu.CurrentState()
+	st.ForEachDirtyStorage(contractaddr, func(key, value common.Hash) bool {
+		if preimage, ok := st.Preimage(key); ok && len(preimage) == 2*common.HashLength && cu.state.GetState(contractaddr, key) != value {
+			touched = append(touched, MappingEntry{
+				Slot:  key,
+				Base:  common.BytesToHash(preimage[common.HashLength:]),
+				Key:   common.BytesToHash(preimage[:common.HashLength]),
+				Value: value,
+			})
+		}
+		return true
+	})
+	return touched
+}
@@ -1,18 +1,46 @@
 package detectors
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	crand "crypto/rand"
+	"encoding/json"
 	"log"
+	"math/big"
+	"minievm/common"
+	"minievm/core"
+	"minievm/core/state"
+	"minievm/core/vm"
 	"minievm/crypto"
+	"minievm/params"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/gofuzz"
 )
 
+//contracts in testdata, compiled with the solc found in PATH
+var (
+	intContract = filepath.Join("testdata", "INT.sol")
+	becContract = filepath.Join("testdata", "BEC.sol")
+)
+
+//requireContract skips the test unless solc and the contract at path are available
+func requireContract(t *testing.T, path string) {
+	if _, err := exec.LookPath("solc"); err != nil {
+		t.Skip("solc not found")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Skip(err)
+	}
+}
+
 func TestGetStorageLoc(t *testing.T) {
+	requireContract(t, intContract)
 	su := &ContractUtils{}
-	su.DeployContracts("~/Documents/zeroklabs/gopath/src/minievm/erc20contracts/INT.sol")
+	su.DeployContracts("solc", intContract)
 	su.SetSkippedVars([]string{})
 	for name, loc := range su.GetStorageLoc() {
 		val := su.evm.StateDB.GetState(su.MainContract.Address, loc)
@@ -20,9 +48,107 @@ func TestGetStorageLoc(t *testing.T) {
 	}
 }
 
+func TestGetTouchedMappingEntries(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	base := common.BigToHash(big.NewInt(1))
+	var slots []common.Hash
+	st := state.New()
+	for i := 0; i < 4; i++ {
+		preimage := append(common.BigToHash(big.NewInt(int64(0x10+i))).Bytes(), base[:]...)
+		slots = append(slots, crypto.Keccak256Hash(preimage))
+		st.AddPreimage(slots[i], preimage)
+	}
+	st.SetState(contract, slots[0], common.BigToHash(big.NewInt(5)))
+	st.SetState(contract, slots[1], common.BigToHash(big.NewInt(7)))
+	st.SetState(contract, slots[2], common.BigToHash(big.NewInt(9)))
+
+	cu := &ContractUtils{state: st, fork: st.Fork()}
+	cu.fork.SetState(contract, slots[0], common.Hash{})
+	cu.fork.SetState(contract, slots[1], common.BigToHash(big.NewInt(7)))
+	cu.fork.SetState(contract, slots[3], common.BigToHash(big.NewInt(3)))
+
+	touched := make(map[common.Hash]MappingEntry)
+	for _, entry := range cu.GetTouchedMappingEntries(contract) {
+		touched[entry.Slot] = entry
+	}
+	if len(touched) != 2 {
+		t.Fatalf("touched entries mismatch: have %v, want slots %x and %x", touched, slots[0], slots[3])
+	}
+	if entry, ok := touched[slots[0]]; !ok || entry.Value != (common.Hash{}) || entry.Base != base || entry.Key != common.BigToHash(big.NewInt(0x10)) {
+		t.Errorf("zeroed entry mismatch: have %+v", entry)
+	}
+	if entry, ok := touched[slots[3]]; !ok || entry.Value != common.BigToHash(big.NewInt(3)) {
+		t.Errorf("new entry mismatch: have %+v", entry)
+	}
+}
+
+func TestStateRoot(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	key := common.BigToHash(big.NewInt(1))
+	st := state.New()
+	st.SetCode(contract, []byte{0x00})
+	st.SetState(contract, key, common.BigToHash(big.NewInt(5)))
+
+	cu := &ContractUtils{state: st}
+	root := cu.StateRoot()
+	cu.fork = st.Fork()
+	if have := cu.StateRoot(); have != root {
+		t.Errorf("fork changed the root: have %x, want %x", have, root)
+	}
+	cu.fork.SetState(contract, key, common.BigToHash(big.NewInt(6)))
+	if cu.StateRoot() == root {
+		t.Errorf("storage write kept the root")
+	}
+	cu.fork.SetState(contract, key, common.BigToHash(big.NewInt(5)))
+	if have := cu.StateRoot(); have != root {
+		t.Errorf("restored state has a different root: have %x, want %x", have, root)
+	}
+}
+
+//newTestUtils returns contract utils on st without deploying anything
+func newTestUtils(st *state.StateDB) *ContractUtils {
+	cu := &ContractUtils{state: st}
+	cu.evm = vm.NewEVM(vm.Context{BlockNumber: big.NewInt(4370001)}, st, params.MainnetChainConfig, vm.Config{})
+	return cu
+}
+
+func TestAllocRoundTrip(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	user := common.BytesToAddress([]byte("user"))
+	st := state.New()
+	st.SetCode(contract, []byte{0x60, 0x00, 0x54})
+	st.AddBalance(contract, big.NewInt(7))
+	st.SetState(contract, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(0x2a)))
+	st.SetState(contract, common.BigToHash(big.NewInt(2)), common.BigToHash(big.NewInt(0x2b)))
+	st.AddBalance(user, big.NewInt(100))
+	st.SetNonce(user, 3)
+	cu := newTestUtils(st)
+
+	path := filepath.Join(t.TempDir(), "alloc.json")
+	if err := core.SaveAlloc(path, cu.DumpAlloc()); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := core.LoadAlloc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestUtils(state.New())
+	loaded.ApplyAlloc(alloc)
+
+	want, _ := json.Marshal(cu.DumpAlloc())
+	have, _ := json.Marshal(loaded.DumpAlloc())
+	if !bytes.Equal(have, want) {
+		t.Errorf("alloc round trip mismatch:\nhave %s\nwant %s", have, want)
+	}
+	if val := loaded.CurrentState().GetState(contract, common.BigToHash(big.NewInt(1))); val != common.BigToHash(big.NewInt(0x2a)) {
+		t.Errorf("loaded storage mismatch: have %x", val)
+	}
+}
+
 func TestABIFuzzing(t *testing.T) {
+	requireContract(t, intContract)
 	su := &ContractUtils{}
-	su.DeployContracts("~/Documents/zeroklabs/gopath/src/minievm/erc20contracts/INT.sol")
+	su.DeployContracts("solc", intContract)
 	transferFunc, _ := su.MainContract.ABI.Methods["changename"]
 	nameGetter, _ := su.MainContract.ABI.Methods["name"]
 
@@ -52,9 +178,10 @@ func TestABIFuzzing(t *testing.T) {
 }
 
 func TestAddressSliceFuzzing(t *testing.T) {
+	path := becContract
+	requireContract(t, path)
 	su := &ContractUtils{}
-	path := "~/Documents/zeroklabs/ContractsDB/etherscan/0xc5d105e63711398af9bbff092d4b6769c82f793d.sol"
-	su.DeployContracts(path)
+	su.DeployContracts("solc", path)
 	batchTransferFunc, _ := su.MainContract.ABI.Methods["batchTransfer"]
 
 	fuzzer := fuzz.New()
@@ -0,0 +1,176 @@
+package detectors
+
+import (
+	"math/big"
+	"math/rand"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/vm"
+	"sync"
+)
+
+//Edge is a conditional branch of a contract, JUMPI at Pc either taken or not
+type Edge struct {
+	CodeHash common.Hash
+	Pc       uint64
+	Taken    bool
+}
+
+type edgeSet struct {
+	sync.Mutex
+	edges map[Edge]struct{}
+}
+
+//Coverage is an evm inspector collecting the branch edges hit by executions
+type Coverage struct {
+	vm.NoopInspector
+	seen    *edgeSet          // all edges seen, shared by the workers of a campaign
+	current map[Edge]struct{} // edges of the execution since the last Reset
+}
+
+//NewCoverage creates an empty coverage map, register it with evm.AddInspector
+func NewCoverage() *Coverage {
+	return &Coverage{seen: &edgeSet{edges: make(map[Edge]struct{})}, current: make(map[Edge]struct{})}
+}
+
+//Share makes c add its edges to the total of other, both can then be used concurrently
+func (c *Coverage) Share(other *Coverage) {
+	c.seen = other.seen
+}
+
+//Jump implements vm.Inspector
+func (c *Coverage) Jump(ctx vm.OpContext, dest uint64, taken bool) {
+	if ctx.Op == vm.JUMPI {
+		c.current[Edge{ctx.CodeHash, ctx.Pc, taken}] = struct{}{}
+	}
+}
+
+//Reset starts a new execution
+func (c *Coverage) Reset() {
+	c.current = make(map[Edge]struct{})
+}
+
+//Merge adds the edges of the current execution to the total and returns how many were new
+func (c *Coverage) Merge() int {
+	c.seen.Lock()
+	defer c.seen.Unlock()
+	added := 0
+	for e := range c.current {
+		if _, ok := c.seen.edges[e]; !ok {
+			c.seen.edges[e] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+//Edges returns the number of edges seen so far
+func (c *Coverage) Edges() int {
+	c.seen.Lock()
+	defer c.seen.Unlock()
+	return len(c.seen.edges)
+}
+
+//Branches returns the number of distinct JUMPIs seen so far
+func (c *Coverage) Branches() int {
+	c.seen.Lock()
+	defer c.seen.Unlock()
+	seen := make(map[Edge]struct{})
+	for e := range c.seen.edges {
+		e.Taken = false
+		seen[e] = struct{}{}
+	}
+	return len(seen)
+}
+
+//Corpus keeps the inputs that reached new edges, per method signature, and the
+//call sequences that did. It is safe for concurrent use.
+type Corpus struct {
+	mu        sync.RWMutex
+	inputs    map[string][][]byte
+	size      int
+	sequences []Sequence
+	dir       string      // cases are also written here if not ""
+	loaded    common.Hash // hash of the cases Open loaded
+}
+
+//NewCorpus creates an empty corpus
+func NewCorpus() *Corpus {
+	return &Corpus{inputs: make(map[string][][]byte)}
+}
+
+//Add stores calldata as interesting for method
+func (c *Corpus) Add(method string, calldata []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inputs[method] = append(c.inputs[method], common.CopyBytes(calldata))
+	c.size++
+}
+
+//Inputs returns copies of the stored inputs of method
+func (c *Corpus) Inputs(method string) [][]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	inputs := make([][]byte, len(c.inputs[method]))
+	for i, input := range c.inputs[method] {
+		inputs[i] = common.CopyBytes(input)
+	}
+	return inputs
+}
+
+//Size returns the number of stored inputs
+func (c *Corpus) Size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.size
+}
+
+//interestingWords are boundary values 256 bit words are replaced with
+var interestingWords = []*big.Int{
+	big.NewInt(0),
+	big.NewInt(1),
+	big.NewInt(0xff),
+	big.NewInt(0x100),
+	big.NewInt(0xffff),
+	big.NewInt(0xffffffff),
+	new(big.Int).Sub(math.BigPow(2, 128), common.Big1),
+	new(big.Int).Sub(math.BigPow(2, 255), common.Big1),
+	math.BigPow(2, 255),
+	math.MaxBig256,
+}
+
+//Mutate returns an AFL-style mutation of calldata, the 4 byte selector is kept.
+//Words are also replaced with constants of dict if it is not nil.
+func Mutate(r *rand.Rand, dict *Dictionary, calldata []byte) []byte {
+	out := common.CopyBytes(calldata)
+	if len(out) <= 4 {
+		return out
+	}
+	args := out[4:]
+	for n := 1 + r.Intn(4); n > 0; n-- {
+		switch r.Intn(6) {
+		case 0: // flip a bit
+			i := r.Intn(len(args))
+			args[i] ^= 1 << uint(r.Intn(8))
+		case 1: // set a random byte
+			args[r.Intn(len(args))] = byte(r.Intn(256))
+		case 2, 3: // replace a word with a boundary value
+			if words := len(args) / 32; words > 0 {
+				w := r.Intn(words)
+				copy(args[w*32:w*32+32], common.LeftPadBytes(interestingWords[r.Intn(len(interestingWords))].Bytes(), 32))
+			}
+		case 4: // add or subtract a small delta from a word
+			if words := len(args) / 32; words > 0 {
+				w := r.Intn(words)
+				v := new(big.Int).SetBytes(args[w*32 : w*32+32])
+				v.Add(v, big.NewInt(int64(r.Intn(33)-16)))
+				copy(args[w*32:w*32+32], common.LeftPadBytes(math.U256(v).Bytes(), 32))
+			}
+		case 5: // replace a word with a constant of the contract
+			if dict != nil {
+				copy(out, dict.Splice(r, out))
+			}
+		}
+	}
+	return out
+}
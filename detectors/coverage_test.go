@@ -0,0 +1,77 @@
+package detectors
+
+import (
+	"bytes"
+	"math/rand"
+	"minievm/core/vm"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	// jumps over the STOP iff the first calldata word is not zero
+	code := asm(0, vm.CALLDATALOAD, ref("end"), vm.JUMPI, vm.STOP, label("end"), vm.STOP)
+	zero, one := make([]byte, 32), append(make([]byte, 31), 1)
+
+	c := NewCoverage()
+	runCode(t, code, zero, c)
+	if added := c.Merge(); added != 1 {
+		t.Fatalf("first run added %d edges, want 1", added)
+	}
+	c.Reset()
+	runCode(t, code, zero, c)
+	if added := c.Merge(); added != 0 {
+		t.Fatalf("same input added %d edges, want 0", added)
+	}
+	c.Reset()
+	runCode(t, code, one, c)
+	if added := c.Merge(); added != 1 {
+		t.Fatalf("other branch added %d edges, want 1", added)
+	}
+	if c.Edges() != 2 || c.Branches() != 1 {
+		t.Fatalf("have %d edges of %d branches, want 2 of 1", c.Edges(), c.Branches())
+	}
+
+	// shared coverage counts edges seen by any worker once
+	other := NewCoverage()
+	other.Share(c)
+	runCode(t, code, one, other)
+	if added := other.Merge(); added != 0 {
+		t.Fatalf("shared edge added again: %d", added)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	c := NewCorpus()
+	c.Add("f()", []byte{1, 2, 3, 4})
+	c.Add("f()", []byte{1, 2, 3, 4, 5})
+	c.Add("g()", []byte{5, 6, 7, 8})
+	if c.Size() != 3 || len(c.Inputs("f()")) != 2 || len(c.Inputs("h()")) != 0 {
+		t.Fatalf("corpus mismatch: size %d, f %v", c.Size(), c.Inputs("f()"))
+	}
+}
+
+func TestMutate(t *testing.T) {
+	calldata := append([]byte{0xde, 0xad, 0xbe, 0xef}, make([]byte, 64)...)
+	changed := false
+	for i := 0; i < 100; i++ {
+		r := rand.New(rand.NewSource(int64(i)))
+		out := Mutate(r, nil, calldata)
+		if len(out) != len(calldata) || !bytes.Equal(out[:4], calldata[:4]) {
+			t.Fatalf("selector or length changed: %x", out)
+		}
+		changed = changed || !bytes.Equal(out, calldata)
+		again := Mutate(rand.New(rand.NewSource(int64(i))), nil, calldata)
+		if !bytes.Equal(out, again) {
+			t.Fatalf("same seed mutated differently: %x and %x", out, again)
+		}
+	}
+	if !changed {
+		t.Fatal("no mutation changed the calldata")
+	}
+	if out := Mutate(rand.New(rand.NewSource(1)), nil, calldata[:4]); !bytes.Equal(out, calldata[:4]) {
+		t.Fatalf("selector only calldata mutated: %x", out)
+	}
+	if !bytes.Equal(calldata[4:], make([]byte, 64)) {
+		t.Fatal("input modified in place")
+	}
+}
@@ -0,0 +1,124 @@
+package detectors
+
+import (
+	"math/big"
+	"math/rand"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/vm"
+)
+
+//MaxDictionarySize caps the number of constants kept per contract
+const MaxDictionarySize = 4096
+
+//Dictionary collects the constants a contract works with: PUSH immediates of its
+//code and operands of comparisons seen at runtime. Magic thresholds (prices,
+//caps, timestamps, owner addresses) are rarely hit by random values, so mutators
+//draw from it. Operands computed from CALLDATALOAD and argument words of the input
+//(copied by CALLDATACOPY) are the fuzzed values themselves and are skipped.
+type Dictionary struct {
+	vm.NoopInspector
+	values []*big.Int
+	seen   map[common.Hash]struct{}
+	taint  *stackTaint
+	fuzzed [2]bool                  // the operands of the current comparison came from calldata
+	input  map[common.Hash]struct{} // argument words of the top level call
+}
+
+//NewDictionary creates an empty dictionary, register it with evm.AddInspector
+//to collect comparison operands
+func NewDictionary() *Dictionary {
+	return &Dictionary{seen: make(map[common.Hash]struct{}), taint: newStackTaint(vm.CALLDATALOAD)}
+}
+
+//Add stores v and reports whether it was new, 0 and 1 are left to the boundary values
+func (d *Dictionary) Add(v *big.Int) bool {
+	if v.Cmp(common.Big1) <= 0 || len(d.values) >= MaxDictionarySize {
+		return false
+	}
+	key := common.BigToHash(v)
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = struct{}{}
+	d.values = append(d.values, new(big.Int).Set(v))
+	return true
+}
+
+//AddCode stores the PUSH1..PUSH32 immediates of code and returns how many were new
+func (d *Dictionary) AddCode(code []byte) int {
+	added := 0
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		if !op.IsPush() {
+			continue
+		}
+		size := int(op-vm.PUSH1) + 1
+		if pc+1+size > len(code) {
+			break
+		}
+		if d.Add(new(big.Int).SetBytes(code[pc+1 : pc+1+size])) {
+			added++
+		}
+		pc += size
+	}
+	return added
+}
+
+//CallEnter implements vm.Inspector
+func (d *Dictionary) CallEnter(ctx vm.OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+	d.taint.enter(ctx.Depth + 1)
+	if ctx.Depth > 0 {
+		return
+	}
+	d.input = make(map[common.Hash]struct{})
+	for w := 4; w+32 <= len(input); w += 32 {
+		d.input[common.BytesToHash(input[w:w+32])] = struct{}{}
+	}
+}
+
+//Step implements vm.Inspector
+func (d *Dictionary) Step(ctx vm.OpContext, stack []*big.Int) {
+	d.taint.step(ctx, stack)
+	switch ctx.Op {
+	case vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ:
+		d.fuzzed = [2]bool{d.taint.tainted(0), d.taint.tainted(1)}
+	}
+}
+
+//Comparison implements vm.Inspector
+func (d *Dictionary) Comparison(ctx vm.OpContext, args []*big.Int, result *big.Int) {
+	for i, arg := range args {
+		if _, ok := d.input[common.BigToHash(arg)]; !ok && !d.fuzzed[i] {
+			d.Add(arg)
+		}
+	}
+}
+
+//Size returns the number of stored constants
+func (d *Dictionary) Size() int {
+	return len(d.values)
+}
+
+//Pick returns a random constant or one of its neighbours, so both sides of a
+//threshold are tried. It returns nil if the dictionary is empty.
+func (d *Dictionary) Pick(r *rand.Rand) *big.Int {
+	if len(d.values) == 0 {
+		return nil
+	}
+	v := new(big.Int).Set(d.values[r.Intn(len(d.values))])
+	return math.U256(v.Add(v, big.NewInt(int64(r.Intn(3)-1))))
+}
+
+//Splice replaces a random 32 byte argument word of calldata with a constant,
+//the 4 byte selector is kept
+func (d *Dictionary) Splice(r *rand.Rand, calldata []byte) []byte {
+	out := common.CopyBytes(calldata)
+	words := (len(out) - 4) / 32
+	if words <= 0 || len(d.values) == 0 {
+		return out
+	}
+	w := 4 + r.Intn(words)*32
+	copy(out[w:w+32], common.LeftPadBytes(d.Pick(r).Bytes(), 32))
+	return out
+}
@@ -0,0 +1,63 @@
+package detectors
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"minievm/common"
+	"minievm/core/vm"
+	"testing"
+)
+
+func TestDictionaryAddCode(t *testing.T) {
+	d := NewDictionary()
+	// 0 and 1 are skipped, 1000 is pushed twice, the PUSH2 at the end is cut off
+	code := append(asm(0, 1, 1000, 1000, 0x1234, vm.ADD), byte(vm.PUSH2), 0x01)
+	if added := d.AddCode(code); added != 2 || d.Size() != 2 {
+		t.Fatalf("added %d, size %d, want 2", added, d.Size())
+	}
+	if added := d.AddCode(code); added != 0 {
+		t.Fatalf("added %d constants twice", added)
+	}
+}
+
+func TestDictionaryComparison(t *testing.T) {
+	d := NewDictionary()
+	input := append([]byte{1, 2, 3, 4}, common.LeftPadBytes([]byte{5}, 32)...)
+	code := asm(
+		// fuzzed < 1234 * 5678, the threshold is only known at runtime
+		1234, 5678, vm.MUL, 4, vm.CALLDATALOAD, vm.LT, vm.POP,
+		// fuzzed + 1 == 99
+		99, 4, vm.CALLDATALOAD, 1, vm.ADD, vm.EQ, vm.POP,
+		// the fuzzed word copied to memory == 98
+		32, 4, 0, vm.CALLDATACOPY, 0, vm.MLOAD, 98, vm.EQ, vm.STOP)
+	runCode(t, code, input, d)
+	for _, v := range []int64{1234 * 5678, 99, 98} {
+		if _, ok := d.seen[common.BigToHash(big.NewInt(v))]; !ok {
+			t.Errorf("operand %d not collected", v)
+		}
+	}
+	if d.Size() != 3 {
+		t.Errorf("fuzzed operands collected: have %v", d.values)
+	}
+}
+
+func TestDictionarySplice(t *testing.T) {
+	d := NewDictionary()
+	r := rand.New(rand.NewSource(1))
+	calldata := append([]byte{1, 2, 3, 4}, make([]byte, 64)...)
+	if out := d.Splice(r, calldata); !bytes.Equal(out, calldata) {
+		t.Fatalf("empty dictionary spliced: %x", out)
+	}
+	d.Add(big.NewInt(1000))
+	for i := 0; i < 50; i++ {
+		out := d.Splice(r, calldata)
+		if !bytes.Equal(out[:4], calldata[:4]) {
+			t.Fatalf("selector changed: %x", out)
+		}
+		first, second := new(big.Int).SetBytes(out[4:36]).Int64(), new(big.Int).SetBytes(out[36:]).Int64()
+		if v := first + second; v < 999 || v > 1001 || first*second != 0 {
+			t.Fatalf("want one word near 1000, have %d and %d", first, second)
+		}
+	}
+}
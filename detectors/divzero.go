@@ -0,0 +1,127 @@
+package detectors
+
+import (
+	"fmt"
+	"math/big"
+	"minievm/common"
+	"minievm/core/vm"
+)
+
+//ZeroDivision is a DIV, SDIV, MOD, SMOD, ADDMOD or MULMOD by zero seen during execution
+type ZeroDivision struct {
+	Op       vm.OpCode
+	Pc       uint64
+	Depth    int
+	Address  common.Address
+	CodeHash common.Hash
+	Args     []*big.Int
+	Reverted bool // the call frame it happened in was reverted
+}
+
+func (z ZeroDivision) String() string {
+	return fmt.Sprintf("%s by zero @PC = %d depth %d code %x args %v", z.Op, z.Pc, z.Depth, z.CodeHash[:4], z.Args)
+}
+
+//DivisionOracle is an evm inspector recording every division by zero of an execution.
+//The EVM pushes 0 instead of failing, so a zero divisor that is not checked silently
+//yields wrong results. Only divisors an attacker can influence are reported: values
+//pushed by CALLDATALOAD or SLOAD are tainted, and so is everything computed from them.
+//Taint is tracked per stack slot, a zero read from storage doesn't taint other zeros.
+type DivisionOracle struct {
+	vm.NoopInspector
+	divisions []ZeroDivision
+	taint     *stackTaint
+	tainted   bool  // the divisor of the current instruction is tainted
+	frames    []int // len(divisions) when each open call frame was entered
+}
+
+//NewDivisionOracle creates an empty oracle, register it with evm.AddInspector
+func NewDivisionOracle() *DivisionOracle {
+	o := &DivisionOracle{}
+	o.Reset()
+	return o
+}
+
+//Step implements vm.Inspector
+func (o *DivisionOracle) Step(ctx vm.OpContext, stack []*big.Int) {
+	o.taint.step(ctx, stack)
+	switch ctx.Op {
+	case vm.DIV, vm.SDIV, vm.MOD, vm.SMOD:
+		o.tainted = o.taint.tainted(1)
+	case vm.ADDMOD, vm.MULMOD:
+		o.tainted = o.taint.tainted(2)
+	}
+}
+
+//Arithmetic implements vm.Inspector
+func (o *DivisionOracle) Arithmetic(ctx vm.OpContext, args []*big.Int, result *big.Int) {
+	var divisor *big.Int
+	switch ctx.Op {
+	case vm.DIV, vm.SDIV, vm.MOD, vm.SMOD:
+		divisor = args[1]
+	case vm.ADDMOD, vm.MULMOD:
+		divisor = args[2]
+	default:
+		return
+	}
+	if divisor.Sign() != 0 || !o.tainted {
+		return
+	}
+	o.divisions = append(o.divisions, ZeroDivision{
+		Op:       ctx.Op,
+		Pc:       ctx.Pc,
+		Depth:    ctx.Depth,
+		Address:  ctx.Address,
+		CodeHash: ctx.CodeHash,
+		Args:     args,
+	})
+}
+
+//CallEnter implements vm.Inspector
+func (o *DivisionOracle) CallEnter(ctx vm.OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+	o.taint.enter(ctx.Depth + 1)
+	o.frames = append(o.frames, len(o.divisions))
+}
+
+//CallExit implements vm.Inspector, divisions of a failed call frame are marked reverted
+func (o *DivisionOracle) CallExit(ctx vm.OpContext, ret []byte, err error) {
+	if len(o.frames) == 0 {
+		return
+	}
+	mark := o.frames[len(o.frames)-1]
+	o.frames = o.frames[:len(o.frames)-1]
+	if err != nil {
+		for i := mark; i < len(o.divisions); i++ {
+			o.divisions[i].Reverted = true
+		}
+	}
+}
+
+//Reset forgets everything recorded, call it before each execution
+func (o *DivisionOracle) Reset() {
+	o.divisions = nil
+	o.taint = newStackTaint(vm.CALLDATALOAD, vm.SLOAD)
+	o.tainted = false
+	o.frames = nil
+}
+
+//Divisions returns all divisions by zero recorded since the last Reset, in execution order
+func (o *DivisionOracle) Divisions() []ZeroDivision {
+	return o.divisions
+}
+
+//Live returns the divisions by zero recorded outside of reverted call frames
+func (o *DivisionOracle) Live() []ZeroDivision {
+	var live []ZeroDivision
+	for _, z := range o.divisions {
+		if !z.Reverted {
+			live = append(live, z)
+		}
+	}
+	return live
+}
+
+//Found reports whether a division by zero outside of reverted call frames was recorded
+func (o *DivisionOracle) Found() bool {
+	return len(o.Live()) > 0
+}
@@ -0,0 +1,43 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/core/vm"
+	"testing"
+)
+
+func TestDivisionOracle(t *testing.T) {
+	zero := make([]byte, 32)
+	seven := append(make([]byte, 31), 7)
+	tests := []struct {
+		name  string
+		code  []byte
+		input []byte
+		found int
+	}{
+		{"constant divisor", asm(0, 10, vm.DIV, vm.STOP), seven, 0},
+		{"calldata divisor", asm(0, vm.CALLDATALOAD, 10, vm.DIV, vm.STOP), zero, 1},
+		{"calldata nonzero", asm(0, vm.CALLDATALOAD, 10, vm.DIV, vm.STOP), seven, 0},
+		{"storage divisor", asm(0, vm.SLOAD, 10, vm.MOD, vm.STOP), nil, 1},
+		{"derived divisor", asm(0, vm.CALLDATALOAD, 0, vm.CALLDATALOAD, vm.SUB, 10, vm.SDIV, vm.STOP), seven, 1},
+		{"mulmod", asm(0, vm.CALLDATALOAD, 3, 2, vm.MULMOD, vm.STOP), zero, 1},
+		{"reverted", asm(0, vm.CALLDATALOAD, 10, vm.DIV, 0, 0, opREVERT), zero, 0},
+		{"constant after zero sload", asm(0, vm.SLOAD, vm.POP, 0, 10, vm.DIV, vm.STOP), nil, 0},
+		{"constant after zero calldata", asm(0, vm.CALLDATALOAD, 0, 10, vm.DIV, vm.STOP), zero, 0},
+		{"swapped divisor", asm(10, 0, vm.CALLDATALOAD, vm.SWAP1, vm.DIV, vm.STOP), zero, 1},
+		{"duplicated divisor", asm(0, vm.CALLDATALOAD, vm.DUP1, vm.POP, 10, vm.DIV, vm.STOP), zero, 1},
+	}
+	for _, test := range tests {
+		o := NewDivisionOracle()
+		runCode(t, test.code, test.input, o)
+		if live := o.Live(); len(live) != test.found {
+			t.Errorf("%s: have %v, want %d divisions by zero", test.name, live, test.found)
+		}
+	}
+	// the divisor is the second operand, x / 0 and not 0 / x
+	o := NewDivisionOracle()
+	runCode(t, asm(big.NewInt(3), 0, vm.CALLDATALOAD, vm.DIV, vm.STOP), zero, o)
+	if o.Found() {
+		t.Errorf("zero dividend reported: %v", o.Live())
+	}
+}
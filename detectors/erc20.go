@@ -0,0 +1,203 @@
+package detectors
+
+import (
+	"fmt"
+	"math/big"
+	"minievm/accounts/abi"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/types"
+	"minievm/crypto"
+)
+
+//erc20Methods are the signatures a contract needs to be checked as an ERC20 token
+var erc20Methods = []string{
+	"totalSupply()",
+	"balanceOf(address)",
+	"allowance(address,address)",
+	"transfer(address,uint256)",
+	"transferFrom(address,address,uint256)",
+	"approve(address,uint256)",
+}
+
+var transferTopic = common.BytesToHash(crypto.Keccak256([]byte("Transfer(address,address,uint256)")))
+
+//erc20Suite checks the ERC20 properties after every call of a sequence:
+//the balances of all holders touched add up to totalSupply, transfers move
+//exactly the value between the two balances, transferFrom spends the allowance,
+//self-transfers change nothing, zero-value transfers don't revert and transfers
+//of more than the balance don't succeed.
+type erc20Suite struct {
+	fi       *FuzzInt
+	holders  map[common.Address]struct{}
+	balanced bool // the balances added up to totalSupply when the sequence started
+}
+
+//erc20Before holds what a call may change, read before it
+type erc20Before struct {
+	balances  map[common.Address]*big.Int
+	allowance *big.Int
+}
+
+//EnableERC20 checks the ERC20 properties on every sequence if the main contract
+//exposes the ERC20 ABI, and reports whether it does
+func (fi *FuzzInt) EnableERC20() bool {
+	fi.erc20 = nil
+	for _, sig := range erc20Methods {
+		found := false
+		for _, method := range fi.maincontract.ABI.Methods {
+			if method.Sig() == sig {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	fi.erc20 = &erc20Suite{fi: fi}
+	return true
+}
+
+//view peeks at a constant function of the token and returns its uint256 result
+func (s *erc20Suite) view(name string, args ...interface{}) *big.Int {
+	packed, err := s.fi.maincontract.ABI.Pack(name, args...)
+	if err != nil {
+		return nil
+	}
+	ret, err := s.fi.contracts.Peek(s.fi.contracts.ContractCreater, s.fi.maincontract.Address, packed)
+	if err != nil || len(ret) < 32 {
+		return nil
+	}
+	return new(big.Int).SetBytes(ret[:32])
+}
+
+func (s *erc20Suite) balanceOf(holder common.Address) *big.Int {
+	return s.view("balanceOf", holder)
+}
+
+//reset starts a sequence on a fresh fork, holding the senders and the token itself
+func (s *erc20Suite) reset(senders []common.Address) {
+	s.holders = map[common.Address]struct{}{s.fi.maincontract.Address: {}}
+	for _, sender := range senders {
+		s.holders[sender] = struct{}{}
+	}
+	supply, sum := s.view("totalSupply"), s.sum()
+	s.balanced = supply != nil && sum != nil && supply.Cmp(sum) == 0
+}
+
+//sum returns the balances of all holders added up, nil if one can't be read
+func (s *erc20Suite) sum() *big.Int {
+	sum := new(big.Int)
+	for holder := range s.holders {
+		balance := s.balanceOf(holder)
+		if balance == nil {
+			return nil
+		}
+		sum.Add(sum, balance)
+	}
+	return sum
+}
+
+//erc20Args decodes the leading address and uint256 arguments of tx
+func erc20Args(tx Tx) (addrs []common.Address, value *big.Int) {
+	if len(tx.Calldata) < 4 {
+		return nil, nil
+	}
+	data := tx.Calldata[4:]
+	for i := 0; i+32 <= len(data); i += 32 {
+		if i/32 < len(tx.Method.Inputs) && tx.Method.Inputs[i/32].Type.T == abi.AddressTy {
+			addrs = append(addrs, common.BytesToAddress(data[i:i+32]))
+		} else {
+			value = new(big.Int).SetBytes(data[i : i+32])
+			break
+		}
+	}
+	return addrs, value
+}
+
+//erc20Parties returns the owner of the tokens moved by tx, their recipient and the
+//value moved, ok is false if tx isn't a complete transfer or transferFrom
+func erc20Parties(tx Tx) (from, to common.Address, value *big.Int, ok bool) {
+	addrs, value := erc20Args(tx)
+	switch {
+	case tx.Method.Name == "transfer" && len(addrs) == 1 && value != nil:
+		return tx.Sender, addrs[0], value, true
+	case tx.Method.Name == "transferFrom" && len(addrs) == 2 && value != nil:
+		return addrs[0], addrs[1], value, true
+	}
+	return common.Address{}, common.Address{}, nil, false
+}
+
+//before tracks the holders tx touches and reads what it may change
+func (s *erc20Suite) before(tx Tx) *erc20Before {
+	s.holders[tx.Sender] = struct{}{}
+	addrs, _ := erc20Args(tx)
+	for _, addr := range addrs {
+		s.holders[addr] = struct{}{}
+	}
+	from, to, _, ok := erc20Parties(tx)
+	if !ok {
+		return nil
+	}
+	b := &erc20Before{balances: map[common.Address]*big.Int{from: s.balanceOf(from), to: s.balanceOf(to)}}
+	if tx.Method.Name == "transferFrom" {
+		b.allowance = s.view("allowance", from, tx.Sender)
+	}
+	return b
+}
+
+//succeeded reports whether ret of a transfer or transferFrom decodes to true.
+//Tokens returning nothing, like USDT, are taken to have succeeded.
+func succeeded(ret []byte) bool {
+	return len(ret) == 0 || (len(ret) == 32 && new(big.Int).SetBytes(ret).Cmp(common.Big1) == 0)
+}
+
+//check describes and identifies the first property tx broke, both are "" if none.
+//logs are the logs tx emitted and ret what it returned, balances and allowance
+//are only checked if it returned true.
+func (s *erc20Suite) check(tx Tx, b *erc20Before, logs []*types.Log, ret []byte, err error) (string, string) {
+	for _, l := range logs {
+		if len(l.Topics) == 3 && l.Topics[0] == transferTopic {
+			s.holders[common.BytesToAddress(l.Topics[1][:])] = struct{}{}
+			s.holders[common.BytesToAddress(l.Topics[2][:])] = struct{}{}
+		}
+	}
+
+	from, to, value, ok := erc20Parties(tx)
+	if ok && err != nil && value.Sign() == 0 && to != (common.Address{}) {
+		return fmt.Sprintf("ERC20: zero-value %s to %x reverted: %v", tx.Method.Name, to[:4], err), "erc20 zero-value " + tx.Method.Name
+	}
+	if err != nil {
+		return "", ""
+	}
+	if ok && succeeded(ret) && b != nil && b.balances[from] != nil && b.balances[to] != nil {
+		if value.Cmp(b.balances[from]) > 0 {
+			return fmt.Sprintf("ERC20: %s of %v succeeded on a balance of %v", tx.Method.Name, value, b.balances[from]), "erc20 insufficient " + tx.Method.Name
+		}
+		fromAfter, toAfter := s.balanceOf(from), s.balanceOf(to)
+		if fromAfter == nil || toAfter == nil {
+			return "", ""
+		}
+		if from == to {
+			if fromAfter.Cmp(b.balances[from]) != 0 {
+				return fmt.Sprintf("ERC20: self-%s of %v changed the balance from %v to %v", tx.Method.Name, value, b.balances[from], fromAfter), "erc20 self-" + tx.Method.Name
+			}
+		} else if new(big.Int).Sub(b.balances[from], fromAfter).Cmp(value) != 0 || new(big.Int).Sub(toAfter, b.balances[to]).Cmp(value) != 0 {
+			return fmt.Sprintf("ERC20: %s of %v moved balances %v -> %v and %v -> %v", tx.Method.Name, value, b.balances[from], fromAfter, b.balances[to], toAfter), "erc20 conservation " + tx.Method.Name
+		}
+		// an allowance of 2^256-1 is commonly treated as infinite
+		if b.allowance != nil && b.allowance.Cmp(math.MaxBig256) != 0 {
+			if after := s.view("allowance", from, tx.Sender); after != nil && new(big.Int).Sub(b.allowance, after).Cmp(value) != 0 {
+				return fmt.Sprintf("ERC20: transferFrom of %v changed the allowance from %v to %v", value, b.allowance, after), "erc20 allowance"
+			}
+		}
+	}
+	if s.balanced {
+		supply, sum := s.view("totalSupply"), s.sum()
+		if supply != nil && sum != nil && supply.Cmp(sum) != 0 {
+			return fmt.Sprintf("ERC20: balances of %d holders add up to %v, totalSupply is %v", len(s.holders), sum, supply), "erc20 supply"
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,181 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/vm"
+	"testing"
+)
+
+//erc20ABI lists the methods of the ERC20 ABI
+const erc20ABI = `
+		{"type":"function","name":"totalSupply","constant":true,"inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+		{"type":"function","name":"balanceOf","constant":true,"inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+		{"type":"function","name":"allowance","constant":true,"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+		{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"transferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}`
+
+//token is an ERC20 whose balances are all ten, its transfers move nothing
+//and end with transferred
+func token(t *testing.T, transferred ...interface{}) *SolcOutput {
+	zero := append([]interface{}{0}, returns...)
+	return compiled(t, "["+erc20ABI+"]", map[string][]interface{}{
+		"totalSupply":  zero,
+		"balanceOf":    append([]interface{}{10}, returns...),
+		"allowance":    zero,
+		"transfer":     transferred,
+		"transferFrom": transferred,
+		"approve":      append([]interface{}{1}, returns...),
+	})
+}
+
+func TestERC20Conservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		transferred []interface{}
+		key         string
+	}{
+		{"returns true", append([]interface{}{1}, returns...), "erc20 conservation transfer"},
+		{"returns nothing", []interface{}{vm.STOP}, "erc20 conservation transfer"},
+		{"returns false", append([]interface{}{0}, returns...), ""},
+	}
+	for _, test := range tests {
+		fi := newTestFuzzer(t, token(t, test.transferred...))
+		if !fi.EnableERC20() {
+			t.Fatalf("%s: not an ERC20", test.name)
+		}
+		transfer := call(t, fi, "transfer", common.StringToAddress("recipient"), big.NewInt(5))
+		if _, finding, key := fi.runCase(Case{Seq: Sequence{transfer}}); key != test.key {
+			t.Errorf("%s: have %q (%s), want %q", test.name, key, finding, test.key)
+		}
+	}
+}
+
+//ledger is an ERC20 keeping balances keyed by holder and allowances keyed by the
+//hash of owner and spender in storage, mint credits the caller. bug names the
+//property it breaks, "" for none.
+func ledger(t *testing.T, bug string) *SolcOutput {
+	supply := math.BigPow(2, 200)
+	mint := []interface{}{4, vm.CALLDATALOAD, vm.CALLER, vm.SLOAD, vm.ADD, vm.CALLER, vm.SSTORE}
+	if bug != "supply" {
+		mint = append(mint, 4, vm.CALLDATALOAD, supply, vm.SLOAD, vm.ADD, supply, vm.SSTORE)
+	}
+	transfer := []interface{}{36, vm.CALLDATALOAD, vm.CALLER, vm.SLOAD, vm.LT, ref("transfer.short"), vm.JUMPI}
+	if bug == "zero" {
+		transfer = append(transfer, 36, vm.CALLDATALOAD, vm.ISZERO, ref("transfer.fail"), vm.JUMPI)
+	}
+	debit := []interface{}{36, vm.CALLDATALOAD, vm.CALLER, vm.SLOAD, vm.SUB, vm.CALLER, vm.SSTORE}
+	if bug == "self" {
+		// the credit is read before the debit is written
+		transfer = append(transfer, 36, vm.CALLDATALOAD, 4, vm.CALLDATALOAD, vm.SLOAD, vm.ADD)
+		transfer = append(transfer, debit...)
+		transfer = append(transfer, 4, vm.CALLDATALOAD, vm.SSTORE)
+	} else {
+		transfer = append(transfer, debit...)
+		transfer = append(transfer, 36, vm.CALLDATALOAD, 4, vm.CALLDATALOAD, vm.SLOAD, vm.ADD, 4, vm.CALLDATALOAD, vm.SSTORE)
+	}
+	transfer = append(append(transfer, 1), returns...)
+	if bug == "insufficient" {
+		transfer = append(append(transfer, label("transfer.short"), 1), returns...)
+	} else {
+		transfer = append(transfer, label("transfer.short"))
+	}
+	transfer = append(transfer, label("transfer.fail"), 0, 0, opREVERT)
+
+	transferFrom := []interface{}{
+		68, vm.CALLDATALOAD, 4, vm.CALLDATALOAD, 0, vm.MSTORE, vm.CALLER, 32, vm.MSTORE, 64, 0, opSHA3, vm.SLOAD, vm.LT, ref("transferFrom.fail"), vm.JUMPI,
+		68, vm.CALLDATALOAD, 4, vm.CALLDATALOAD, vm.SLOAD, vm.LT, ref("transferFrom.fail"), vm.JUMPI,
+	}
+	if bug != "allowance" {
+		transferFrom = append(transferFrom, 68, vm.CALLDATALOAD, 64, 0, opSHA3, vm.SLOAD, vm.SUB, 64, 0, opSHA3, vm.SSTORE)
+	}
+	transferFrom = append(transferFrom,
+		68, vm.CALLDATALOAD, 4, vm.CALLDATALOAD, vm.SLOAD, vm.SUB, 4, vm.CALLDATALOAD, vm.SSTORE,
+		68, vm.CALLDATALOAD, 36, vm.CALLDATALOAD, vm.SLOAD, vm.ADD, 36, vm.CALLDATALOAD, vm.SSTORE, 1)
+	transferFrom = append(append(transferFrom, returns...), label("transferFrom.fail"), 0, 0, opREVERT)
+
+	return compiled(t, "["+erc20ABI+`,
+		{"type":"function","name":"mint","inputs":[{"name":"value","type":"uint256"}],"outputs":[]}
+	]`, map[string][]interface{}{
+		"totalSupply":  append([]interface{}{supply, vm.SLOAD}, returns...),
+		"balanceOf":    append([]interface{}{4, vm.CALLDATALOAD, vm.SLOAD}, returns...),
+		"allowance":    append([]interface{}{4, vm.CALLDATALOAD, 0, vm.MSTORE, 36, vm.CALLDATALOAD, 32, vm.MSTORE, 64, 0, opSHA3, vm.SLOAD}, returns...),
+		"transfer":     transfer,
+		"transferFrom": transferFrom,
+		"approve":      append([]interface{}{vm.CALLER, 0, vm.MSTORE, 4, vm.CALLDATALOAD, 32, vm.MSTORE, 36, vm.CALLDATALOAD, 64, 0, opSHA3, vm.SSTORE, 1}, returns...),
+		"mint":         append(mint, vm.STOP),
+	})
+}
+
+func TestERC20Properties(t *testing.T) {
+	recipient := common.StringToAddress("recipient")
+	tests := []struct {
+		bug, key string
+		seq      func(fi *FuzzInt) Sequence
+	}{
+		{"supply", "erc20 supply", func(fi *FuzzInt) Sequence {
+			return Sequence{call(t, fi, "mint", big.NewInt(10))}
+		}},
+		{"allowance", "erc20 allowance", func(fi *FuzzInt) Sequence {
+			attacker := fi.contracts.ContractAttacker
+			return Sequence{
+				call(t, fi, "mint", big.NewInt(10)),
+				call(t, fi, "approve", attacker, big.NewInt(5)),
+				call(t, fi, "transferFrom", attacker, recipient, big.NewInt(5)),
+			}
+		}},
+		{"self", "erc20 self-transfer", func(fi *FuzzInt) Sequence {
+			return Sequence{call(t, fi, "mint", big.NewInt(10)), call(t, fi, "transfer", fi.contracts.ContractAttacker, big.NewInt(5))}
+		}},
+		{"zero", "erc20 zero-value transfer", func(fi *FuzzInt) Sequence {
+			return Sequence{call(t, fi, "transfer", recipient, big.NewInt(0))}
+		}},
+		{"insufficient", "erc20 insufficient transfer", func(fi *FuzzInt) Sequence {
+			return Sequence{call(t, fi, "mint", big.NewInt(10)), call(t, fi, "transfer", recipient, big.NewInt(20))}
+		}},
+	}
+	for _, test := range tests {
+		for _, bug := range []string{"", test.bug} {
+			fi := newTestFuzzer(t, ledger(t, bug))
+			if !fi.EnableERC20() {
+				t.Fatalf("%s: not an ERC20", test.bug)
+			}
+			want := ""
+			if bug != "" {
+				want = test.key
+			}
+			if _, finding, key := fi.runCase(Case{Seq: test.seq(fi)}); key != want {
+				t.Errorf("%s with bug %q: have %q (%s), want %q", test.bug, bug, key, finding, want)
+			}
+		}
+	}
+}
+
+func TestERC20Peek(t *testing.T) {
+	zero := append([]interface{}{0}, returns...)
+	fi := newTestFuzzer(t, compiled(t, "["+erc20ABI+"]", map[string][]interface{}{
+		"totalSupply": zero,
+		// overflows and writes storage
+		"balanceOf":    append([]interface{}{math.MaxBig256, 1, vm.ADD, 7, vm.SSTORE, 0}, returns...),
+		"allowance":    zero,
+		"transfer":     {vm.STOP},
+		"transferFrom": {vm.STOP},
+		"approve":      {vm.STOP},
+	}))
+	if !fi.EnableERC20() {
+		t.Fatal("not an ERC20")
+	}
+	fi.contracts.ForkStates()
+	fi.oracle.Reset()
+	if balance := fi.erc20.balanceOf(fi.contracts.ContractAttacker); balance == nil || balance.Sign() != 0 {
+		t.Fatalf("balance %v, want 0", balance)
+	}
+	if fi.oracle.Found() {
+		t.Errorf("oracle saw the view: %v", fi.oracle.Live())
+	}
+	if v := fi.contracts.GetStorage(common.BigToHash(big.NewInt(7))); v != (common.Hash{}) {
+		t.Errorf("view wrote %x", v)
+	}
+}
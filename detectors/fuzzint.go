@@ -1,16 +1,19 @@
 package detectors
 
 import (
-	"bufio"
 	"bytes"
-	"crypto/rand"
 	"fmt"
 	"io"
 	"log"
 	"math/big"
+	mrand "math/rand"
+	"minievm/accounts/abi"
 	"minievm/common"
+	"minievm/core"
+	"minievm/core/state"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	ui "github.com/gizak/termui"
@@ -29,16 +32,27 @@ type FuzzInt struct {
 	constantsLoc               map[string]common.Hash
 	constantsName              []string
 	enableUI                   bool
+	oracle                     *ArithmeticOracle
+	divOracle                  *DivisionOracle
+	coverage                   *Coverage
+	corpus                     *Corpus
+	rand                       *mrand.Rand
+	seed                       int64
+	iteration                  int
+	report                     *lockedWriter // findings of all workers of a campaign
+	shard, shards              int       // this worker fuzzes storage rounds shard, shard+shards, ...
+	invariants                 []abi.Method
+	erc20                      *erc20Suite
+	baseStorage                map[common.Hash]*big.Int
+	dict                       *Dictionary
+	minimizing                 bool // cases run by Minimize don't feed coverage and corpus
 }
 
-func GenRandomInSpecialDist() *big.Int {
+func GenRandomInSpecialDist(r *mrand.Rand) *big.Int {
 	maxrange := int64(16)
-	chooseRange, err := rand.Int(rand.Reader, big.NewInt(4))
-	if err != nil {
-		//error handling
-	}
+	chooseRange := big.NewInt(r.Int63n(4))
 
-	n, _ := rand.Int(rand.Reader, big.NewInt(maxrange))
+	n := big.NewInt(r.Int63n(maxrange))
 	/*
 		[0, 2^256-1] split into 3 parts
 		[0, 2^10-1], [2^255-100, 2^255+99], [2^256-2^10, 2^256-1]
@@ -64,18 +78,40 @@ func GenRandomInSpecialDist() *big.Int {
 }
 
 func (fi *FuzzInt) FuzzStorage() {
-	for _, loc := range fi.constantsLoc {
-		n := GenRandomInSpecialDist()
+	for _, name := range fi.constantsName {
+		loc := fi.constantsLoc[name]
+		n := GenRandomInSpecialDist(fi.rand)
+		if fi.dict.Size() > 0 && fi.rand.Intn(2) == 0 {
+			n = fi.dict.Pick(fi.rand)
+		}
 		fi.contracts.SetStorage(loc, n)
 	}
 }
 
-func NewContractFuzzer(solcpath, contractpath, logpath string, enableUI bool) *FuzzInt {
-	fi := &FuzzInt{path: contractpath}
-	fi.fuzzer = fuzz.New()
-	fi.contracts = NewContract(solcpath, fi.path)
+//NewContractFuzzer creates a fuzzer for the contracts at contractpath, all its
+//randomness flows from seed so a run can be replayed exactly
+func NewContractFuzzer(solcpath, contractpath, logpath string, enableUI bool, seed int64) *FuzzInt {
+	return newFuzzer(NewContract(solcpath, contractpath), contractpath, logpath, enableUI, seed)
+}
+
+//newFuzzer creates a fuzzer for the deployed contracts, compiled from contractpath
+func newFuzzer(contracts *ContractUtils, contractpath, logpath string, enableUI bool, seed int64) *FuzzInt {
+	fi := &FuzzInt{path: contractpath, seed: seed, shards: 1}
+	fi.rand = mrand.New(mrand.NewSource(seed))
+	fi.fuzzer = fuzz.New().RandSource(fi.rand)
+	fi.contracts = contracts
 	fi.maincontract = &fi.contracts.MainContract
 	fi.constantsLoc = fi.contracts.GetStorageLoc()
+	fi.oracle = NewArithmeticOracle()
+	fi.contracts.AddInspector(fi.oracle)
+	fi.divOracle = NewDivisionOracle()
+	fi.contracts.AddInspector(fi.divOracle)
+	fi.coverage = NewCoverage()
+	fi.contracts.AddInspector(fi.coverage)
+	fi.corpus = NewCorpus()
+	fi.dict = NewDictionary()
+	fi.contracts.AddInspector(fi.dict)
+	fi.harvestCode()
 	fi.enableUI = enableUI
 	patharray := strings.Split(fi.path, "/")
 	fi.logfilename = "log_" + patharray[len(patharray)-1] + ".txt"
@@ -83,9 +119,143 @@ func NewContractFuzzer(solcpath, contractpath, logpath string, enableUI bool) *F
 	for name := range fi.constantsLoc {
 		fi.constantsName = append(fi.constantsName, name)
 	}
+	sort.Strings(fi.constantsName)
 	return fi
 }
 
+//Seed returns the seed the fuzzer was created with
+func (fi *FuzzInt) Seed() int64 {
+	return fi.seed
+}
+
+//methods returns the methods of the main contract that can change state, by name
+func (fi *FuzzInt) methods() []abi.Method {
+	var methods []abi.Method
+	for _, method := range fi.maincontract.ABI.Methods {
+		if !method.Const && !fi.isInvariant(method) {
+			methods = append(methods, method)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+//LoadAlloc seeds the fuzzing state with the genesis alloc file at allocpath
+func (fi *FuzzInt) LoadAlloc(allocpath string) error {
+	alloc, err := core.LoadAlloc(allocpath)
+	if err != nil {
+		return err
+	}
+	fi.contracts.ApplyAlloc(alloc)
+	return nil
+}
+
+//SaveAlloc writes the deployed state to the genesis alloc file at allocpath,
+//LoadAlloc seeds later runs with it
+func (fi *FuzzInt) SaveAlloc(allocpath string) error {
+	return core.SaveAlloc(allocpath, fi.contracts.DumpAlloc())
+}
+
+//SaveStates checkpoints the deployed state into the LevelDB at path and returns
+//the id to load it back with
+func (fi *FuzzInt) SaveStates(path string) (common.Hash, error) {
+	return fi.contracts.SaveStates(path)
+}
+
+//LoadStates fuzzes on the checkpoint SaveStates wrote under id instead of the
+//deployed state
+func (fi *FuzzInt) LoadStates(path string, id common.Hash) error {
+	if err := fi.contracts.LoadStates(path, id); err != nil {
+		return err
+	}
+	fi.baseStorage = nil
+	fi.harvestCode()
+	return nil
+}
+
+//AttachRemote fuzzes the contract deployed at addr, reading chain state through backend
+func (fi *FuzzInt) AttachRemote(backend state.Backend, addr common.Address) error {
+	if err := fi.contracts.AttachRemote(backend, addr); err != nil {
+		return err
+	}
+	fi.maincontract = &fi.contracts.MainContract
+	fi.harvestCode()
+	return nil
+}
+
+//harvestCode adds the PUSH immediates of the main contract's code to the dictionary
+func (fi *FuzzInt) harvestCode() {
+	fi.dict.AddCode(fi.contracts.CurrentState().GetCode(fi.maincontract.Address))
+}
+
+//nextInput mutates an input of method that reached new edges before, or
+//generates a fresh one now and then and while there are none. Half of the fresh
+//ones get a constant of the contract.
+func (fi *FuzzInt) nextInput(method abi.Method) []byte {
+	inputs := fi.corpus.Inputs(method.Sig())
+	if len(inputs) > 0 && fi.rand.Intn(4) != 0 {
+		return Mutate(fi.rand, fi.dict, inputs[fi.rand.Intn(len(inputs))])
+	}
+	calldata, _ := method.Fuzz(fi.fuzzer)
+	if fi.rand.Intn(2) == 0 {
+		return fi.dict.Splice(fi.rand, calldata)
+	}
+	return calldata
+}
+
+//coverageText summarizes the coverage for the dashboard
+func (fi *FuzzInt) coverageText() string {
+	return fmt.Sprintf("edges: %d  branches: %d  corpus: %d  constants: %d", fi.coverage.Edges(), fi.coverage.Branches(), fi.corpus.Size(), fi.dict.Size())
+}
+
+//logFinding minimizes the finding of the last call of method with calldata,
+//made on the current storage, and writes it to w
+func (fi *FuzzInt) logFinding(w io.Writer, method abi.Method, calldata []byte) {
+	finding, key := fi.finding(nil)
+	if !fi.claim(nil, key) {
+		return
+	}
+	c := Case{Storage: fi.storageValues(), Seq: Sequence{{fi.contracts.ContractCreater, method, calldata}}}
+	fi.logCase(w, c, finding, key)
+}
+
+//claim marks the finding identified by key as found and reports whether it is
+//new: to the campaign for its workers, to found otherwise. A nil found keeps
+//no record, every finding is new.
+func (fi *FuzzInt) claim(found map[string]bool, key string) bool {
+	if fi.report != nil {
+		return fi.report.claim(key)
+	}
+	if found == nil {
+		return true
+	}
+	if found[key] {
+		return false
+	}
+	found[key] = true
+	return true
+}
+
+//openLog returns where findings are written: the report of the campaign for
+//its workers, the log file opened with flag otherwise
+func (fi *FuzzInt) openLog(flag int) (io.Writer, func() error, error) {
+	if fi.report != nil {
+		return fi.report, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(fi.logpath, os.O_CREATE|os.O_WRONLY|flag, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+//keep adds c to the corpus
+func (fi *FuzzInt) keep(c Case) {
+	if err := fi.corpus.AddCase(c); err != nil {
+		log.Print("Corpus write err...", err)
+	}
+}
+
 func (fi *FuzzInt) getConstantsTable() [][]string {
 	rownum := len(fi.constantsName)
 	table := make([][]string, rownum+1)
@@ -124,7 +294,7 @@ const (
 
 func (fi *FuzzInt) CheckEvent() bool {
 	overflowTopic := common.Hex2Bytes("FEE46111846A282E8199035721DD0334C2BC5C016AE4E72B924003431D6A8759")
-	for _, logentry := range fi.contracts.state.Logs {
+	for _, logentry := range fi.contracts.CurrentState().Logs {
 		if len(logentry.Topics) > 0 {
 			logbytes := logentry.Topics[0].Bytes()
 			if bytes.Equal(overflowTopic, logbytes) {
@@ -138,34 +308,13 @@ func (fi *FuzzInt) CheckEvent() bool {
 	return false
 }
 
-func (fi *FuzzInt) CheckOverflowStorage() bool {
-	ops := []string{add, sub, mul, div}
-
-	for _, op := range ops {
-		res := fi.maincontract.evm.StateDB.GetState(fi.maincontract.Address, common.StringToHash(op))
-		emptyHash := common.Hash{}
-		if res != emptyHash {
-			// log.Printf("Attack Vector: %02x\nUnpacked Input: %02x", packed, product)
-			// log.Println(op[10:], "@PC =", res.Big())
-			// log.Println()
-			return true
-		}
-	}
-	// ret, err = ofd.CallFunctionStub("stub_overflowdetection")
-	// evm.StateDB.SetState(contract.Address(), common.StringToHash(key), common.BigToHash(big.NewInt(int64(*pc))))
-
-	return false
-}
-
 func (fi *FuzzInt) FuzzContracts() {
 	// log.Print(fi.logpath)
-	f, err := os.Create(fi.logpath)
+	w, closeLog, err := fi.openLog(os.O_TRUNC)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer f.Close()
-
-	w := bufio.NewWriter(f)
+	defer closeLog()
 
 	if fi.enableUI {
 		err = ui.Init()
@@ -224,17 +373,22 @@ func (fi *FuzzInt) FuzzContracts() {
 	calldataLable.Height = 14
 	calldataLable.Width = table.Width - g.Width - 1
 	calldataLable.TextFgColor = ui.ColorWhite
+
+	coverageLable := ui.NewPar(fi.coverageText())
+	coverageLable.BorderLabel = "Coverage"
+	coverageLable.Height = 3
+	coverageLable.Width = table.Width
+	coverageLable.TextFgColor = ui.ColorWhite
 	// evmLable.Text
 
 	// log.Print(fi.maincontract.Name)
-	for _, method := range fi.maincontract.ABI.Methods {
-		if method.Const {
-			continue
-		}
+	fi.deployedStorage()
+fuzz:
+	for _, method := range fi.methods() {
 		attackVectorCnt := 0
 		g.BorderLabel = method.Name
 	methodFuzz:
-		for i := 0; i < StorageMutateNum; i++ {
+		for i := fi.shard; i < StorageMutateNum; i += fi.shards {
 			fi.FuzzStorage()
 			table.Rows = fi.getConstantsTable()
 			table.Height = len(table.Rows)*2 + 1
@@ -242,6 +396,7 @@ func (fi *FuzzInt) FuzzContracts() {
 			calldataLable.Y = g.Y
 			gTotal.Y = g.Y + g.Height + 1
 			evmLable.Y = gTotal.Y + gTotal.Height + 1
+			coverageLable.Y = evmLable.Y + evmLable.Height + 1
 			if fi.enableUI {
 				ui.Render(evmLable)
 			}
@@ -251,17 +406,36 @@ func (fi *FuzzInt) FuzzContracts() {
 				if fi.enableUI {
 					ui.Render(g)
 				}
-				calldata, _ := method.Fuzz(fi.fuzzer)
+				calldata := fi.nextInput(method)
+				fi.iteration++
 
-				fi.contracts.BackupStates()
+				fi.contracts.ForkStates()
+				fi.oracle.Reset()
+				fi.divOracle.Reset()
+				fi.coverage.Reset()
 				_, err := fi.maincontract.Call(fi.contracts.ContractCreater, calldata)
 				// PrintMemUsage()
 				// log.Printf("Call Func: %s with %02x\n", method.Name, calldata)
 				calldataLable.Text = common.ToHex(calldata)
 				// eventExist := fi.CheckEvent() // require src transformer
 				eventExist := false
-				overflowStateExist := fi.CheckOverflowStorage()
-				fi.contracts.RestoreStates()
+				overflowFound := fi.oracle.Found()
+				divByZeroFound := fi.divOracle.Found()
+				newEdges := fi.coverage.Merge() > 0
+				if newEdges {
+					coverageLable.Text = fi.coverageText()
+					if fi.enableUI {
+						ui.Render(coverageLable)
+					}
+				}
+				fi.contracts.DiscardStates()
+				if err := fi.contracts.CurrentState().Error(); err != nil {
+					log.Print("State err...", err)
+					break fuzz
+				}
+				if newEdges {
+					fi.keep(Case{Storage: fi.storageValues(), Seq: Sequence{{fi.contracts.ContractCreater, method, calldata}}})
+				}
 
 				if err == nil {
 					if eventExist {
@@ -274,16 +448,24 @@ func (fi *FuzzInt) FuzzContracts() {
 						fi.GenTable(method.Sig(), common.ToHex(calldata), w)
 						attackVectorCnt++
 						// result:= strings.Sprintf("Current state: %s\nInput: %s\n",
-					} else if overflowStateExist {
+					} else if overflowFound {
 						evmLable.Text = "Non-revert Detected\n" + calldataLable.Text + "\n"
 						// evmLable.Text += fi.contracts.GetStorage(fi.constantsLoc["sellPrice"]).String()
-						evmLable.Text += "\nOverflow State: " + fmt.Sprintf("%v", overflowStateExist)
+						evmLable.Text += "\nOverflow: " + fi.oracle.Live()[0].String()
 						if fi.enableUI {
 							ui.Render(evmLable, calldataLable)
 						}
-						fi.GenTable(method.Sig(), common.ToHex(calldata), w)
+						fi.logFinding(w, method, calldata)
 						attackVectorCnt++
 						// result:= strings.Sprintf("Current state: %s\nInput: %s\n",
+					} else if divByZeroFound {
+						evmLable.Text = "Non-revert Detected\n" + calldataLable.Text + "\n"
+						evmLable.Text += "\nDivision: " + fi.divOracle.Live()[0].String()
+						if fi.enableUI {
+							ui.Render(evmLable, calldataLable)
+						}
+						fi.logFinding(w, method, calldata)
+						attackVectorCnt++
 					}
 				}
 				// method.Fuzz(fi.fuzzer)
@@ -291,12 +473,11 @@ func (fi *FuzzInt) FuzzContracts() {
 				// log.Printf("name :%s, %02x\n", method.Sig(), calldata)
 				if j == 0 {
 					if fi.enableUI {
-						ui.Render(p, table, gTotal, calldataLable)
+						ui.Render(p, table, gTotal, calldataLable, coverageLable)
 					}
 				}
 
 				if attackVectorCnt > 5 {
-					w.Flush()
 					break methodFuzz
 				}
 			}
@@ -2,6 +2,7 @@ package detectors
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
 	"math/big"
 	"minievm/common"
@@ -9,15 +10,15 @@ import (
 )
 
 func TestFuzzContracts(t *testing.T) {
-	path := "~/Documents/zeroklabs/gopath/src/minievm/erc20contracts/t_BEC.sol"
-	logpath := "~/Documents/zeroklabs/gopath/src/minievm/fuzz_log"
-	cf := NewContractFuzzer(path, logpath, true)
+	path := becContract
+	requireContract(t, path)
+	cf := NewContractFuzzer("solc", path, t.TempDir(), true, 1)
 	cf.FuzzContracts()
 }
 
 func checkEvent(fi *FuzzInt) bool {
 	overflowTopic := common.Hex2Bytes("FEE46111846A282E8199035721DD0334C2BC5C016AE4E72B924003431D6A8759")
-	for _, logentry := range fi.contracts.state.Logs {
+	for _, logentry := range fi.contracts.CurrentState().Logs {
 		logbytes := logentry.Topics[0].Bytes()
 		if bytes.Equal(overflowTopic, logbytes) {
 			return true
@@ -26,18 +27,43 @@ func checkEvent(fi *FuzzInt) bool {
 	return false
 }
 func TestSingleCall(t *testing.T) {
-	path := "~/Documents/zeroklabs/gopath/src/minievm/erc20contracts/INT.sol"
-	logpath := "~/Documents/zeroklabs/gopath/src/minievm/fuzz_log"
-	fi := NewContractFuzzer(path, logpath, false)
+	path := intContract
+	requireContract(t, path)
+	fi := NewContractFuzzer("solc", path, t.TempDir(), false, 1)
 	n := new(big.Int)
 	n.Exp(big.NewInt(2), big.NewInt(255), nil)
 	loc := fi.constantsLoc["sellPrice"]
 	log.Printf("%02x, %02x\n", fi.contracts.GetStorage(loc), fi.constantsLoc["sellPrice"])
 	fi.contracts.SetStorage(loc, n)
 	log.Printf("%02x, %02x\n", fi.contracts.GetStorage(loc), fi.constantsLoc["sellPrice"])
-	fi.contracts.BackupStates()
+	fi.contracts.ForkStates()
 	ret, err := fi.maincontract.Call(fi.contracts.ContractCreater, common.Hex2Bytes("e4849b320000000000000000000000000000000000000000000000000000000000000008"))
-	fi.contracts.RestoreStates()
 	log.Print(ret, err)
 	log.Print(checkEvent(fi))
+	fi.contracts.DiscardStates()
+}
+
+func TestSeedReplay(t *testing.T) {
+	run := func(seed int64) (string, []Sequence) {
+		fi := newFuzzer(newCompiledContract(armed(t)), "Test.sol", t.TempDir(), false, seed)
+		fi.FuzzSequences(50)
+		out, err := ioutil.ReadFile(fi.logpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out), fi.corpus.Sequences()
+	}
+	log1, seqs1 := run(42)
+	log2, seqs2 := run(42)
+	if log1 == "" || log1 != log2 {
+		t.Fatalf("same seed logged differently:\n%s\n%s", log1, log2)
+	}
+	if len(seqs1) != len(seqs2) {
+		t.Fatalf("same seed kept %d and %d sequences", len(seqs1), len(seqs2))
+	}
+	for i := range seqs1 {
+		if seqs1[i].String() != seqs2[i].String() {
+			t.Fatalf("sequence %d differs:\n%s\n%s", i, seqs1[i], seqs2[i])
+		}
+	}
 }
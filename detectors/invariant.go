@@ -0,0 +1,68 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/accounts/abi"
+	"minievm/common"
+	"sort"
+	"strings"
+)
+
+//DefaultInvariantPrefix names the invariant functions of echidna test contracts
+const DefaultInvariantPrefix = "echidna_"
+
+//SetInvariantPrefix makes the constant, zero argument, bool returning functions
+//of the main contract whose name starts with prefix invariants. They are called
+//on the deployed state and after every transaction of a sequence, one returning
+//false or reverting is a finding. It returns the number of invariants found.
+func (fi *FuzzInt) SetInvariantPrefix(prefix string) int {
+	fi.invariants = nil
+	for name, method := range fi.maincontract.ABI.Methods {
+		if !strings.HasPrefix(name, prefix) || !method.Const || len(method.Inputs) != 0 {
+			continue
+		}
+		if len(method.Outputs) == 1 && method.Outputs[0].Type.T == abi.BoolTy {
+			fi.invariants = append(fi.invariants, method)
+		}
+	}
+	sort.Slice(fi.invariants, func(i, j int) bool { return fi.invariants[i].Name < fi.invariants[j].Name })
+	return len(fi.invariants)
+}
+
+func (fi *FuzzInt) isInvariant(method abi.Method) bool {
+	for _, invariant := range fi.invariants {
+		if invariant.Name == method.Name {
+			return true
+		}
+	}
+	return false
+}
+
+//brokenInvariant calls the invariants on the current state and describes and
+//identifies the first one that doesn't hold, both are "" if all do. Whatever
+//an invariant writes is reverted, so it can't change what the sequence sees.
+func (fi *FuzzInt) brokenInvariant() (string, string) {
+	st := fi.contracts.CurrentState()
+	for _, invariant := range fi.invariants {
+		snapshot := st.Snapshot()
+		ret, err := fi.maincontract.Call(fi.contracts.ContractCreater, invariant.Id())
+		st.RevertToSnapshot(snapshot)
+		switch {
+		case err != nil:
+			return "Invariant: " + invariant.Name + " reverted: " + err.Error(), "invariant " + invariant.Name
+		case len(ret) != 32 || new(big.Int).SetBytes(ret).Cmp(common.Big1) != 0:
+			return "Invariant: " + invariant.Name + " returned false", "invariant " + invariant.Name
+		}
+	}
+	return "", ""
+}
+
+//deployedInvariant checks the invariants on the deployed state, before any call
+func (fi *FuzzInt) deployedInvariant() (string, string) {
+	if len(fi.invariants) == 0 {
+		return "", ""
+	}
+	fi.forkCase(Case{})
+	defer fi.contracts.DiscardStates()
+	return fi.brokenInvariant()
+}
@@ -0,0 +1,66 @@
+package detectors
+
+import (
+	"io/ioutil"
+	"minievm/common"
+	"minievm/core/vm"
+	"strings"
+	"testing"
+)
+
+//guarded breaks echidna_disarmed once arm was called, echidna_sloppy writes
+//storage and echidna_state isn't constant
+func guarded(t *testing.T, deployedBroken bool) *SolcOutput {
+	disarmed := []interface{}{0, vm.SLOAD, vm.ISZERO}
+	if deployedBroken {
+		disarmed = []interface{}{0}
+	}
+	return compiled(t, `[
+		{"type":"function","name":"arm","inputs":[],"outputs":[]},
+		{"type":"function","name":"echidna_disarmed","constant":true,"inputs":[],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"echidna_sloppy","constant":true,"inputs":[],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"echidna_state","inputs":[],"outputs":[{"name":"","type":"bool"}]}
+	]`, map[string][]interface{}{
+		"arm":              {1, 0, vm.SSTORE, vm.STOP},
+		"echidna_disarmed": append(disarmed, returns...),
+		"echidna_sloppy":   append([]interface{}{1, 1, vm.SSTORE, 1}, returns...),
+		"echidna_state":    append([]interface{}{0}, returns...),
+	})
+}
+
+func TestInvariants(t *testing.T) {
+	fi := newTestFuzzer(t, guarded(t, false))
+	if n := fi.SetInvariantPrefix(DefaultInvariantPrefix); n != 2 {
+		t.Fatalf("found %d invariants, want the 2 constant ones", n)
+	}
+	if methods := fi.methods(); len(methods) != 2 || methods[0].Name != "arm" || methods[1].Name != "echidna_state" {
+		t.Fatalf("invariants fuzzed as methods: %v", methods)
+	}
+
+	fi.contracts.ForkStates()
+	if finding, _ := fi.brokenInvariant(); finding != "" {
+		t.Fatalf("deployed state breaks %s", finding)
+	}
+	if v := fi.contracts.GetStorage(common.BigToHash(common.Big1)); v != (common.Hash{}) {
+		t.Fatalf("invariant write kept: %x", v)
+	}
+	fi.contracts.DiscardStates()
+
+	n, finding, key := fi.runCase(Case{Seq: Sequence{call(t, fi, "arm")}})
+	if n != 0 || key != "invariant echidna_disarmed" || !strings.HasSuffix(finding, "returned false") {
+		t.Fatalf("have call %d finding %q key %q", n, finding, key)
+	}
+}
+
+func TestDeployedInvariant(t *testing.T) {
+	fi := newTestFuzzer(t, guarded(t, true))
+	fi.SetInvariantPrefix(DefaultInvariantPrefix)
+	fi.FuzzSequences(20)
+	out, err := ioutil.ReadFile(fi.logpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(out), "echidna_disarmed"); n != 1 || !strings.Contains(string(out), "returned false on the deployed state") {
+		t.Fatalf("want one finding on the deployed state, have %d:\n%s", n, out)
+	}
+}
@@ -0,0 +1,144 @@
+package detectors
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"minievm/common"
+	"sort"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+//Case is a reproducible finding: storage of the main contract set on a fork of
+//the deployed state, then the calls of Seq. Slots missing from Storage keep
+//their deployed value.
+type Case struct {
+	Storage map[common.Hash]*big.Int
+	Seq     Sequence
+}
+
+func (c Case) clone() Case {
+	cp := Case{Storage: make(map[common.Hash]*big.Int, len(c.Storage)), Seq: make(Sequence, len(c.Seq))}
+	for loc, value := range c.Storage {
+		cp.Storage[loc] = value
+	}
+	for i, tx := range c.Seq {
+		tx.Calldata = common.CopyBytes(tx.Calldata)
+		cp.Seq[i] = tx
+	}
+	return cp
+}
+
+//storageValues reads the current values of the constants' storage slots
+func (fi *FuzzInt) storageValues() map[common.Hash]*big.Int {
+	values := make(map[common.Hash]*big.Int)
+	for _, loc := range fi.constantsLoc {
+		values[loc] = fi.contracts.GetStorage(loc).Big()
+	}
+	return values
+}
+
+//deployedStorage returns the values of the constants' storage slots before
+//FuzzStorage mutated them
+func (fi *FuzzInt) deployedStorage() map[common.Hash]*big.Int {
+	if fi.baseStorage == nil {
+		fi.baseStorage = fi.storageValues()
+	}
+	return fi.baseStorage
+}
+
+//reproduces reports whether the last call of c has the finding identified by key
+func (fi *FuzzInt) reproduces(c Case, key string) (string, bool) {
+	n, finding, k := fi.runCase(c)
+	return finding, n == len(c.Seq)-1 && k == key
+}
+
+//Minimize shrinks c as long as its last call still has the finding identified
+//by key: calls are dropped, storage mutations reset to the deployed value, and
+//the remaining storage values and calldata arguments moved toward 0, 1 and
+//boundary values. It returns the smallest case found and its finding, which is
+//"" if c does not reproduce at all. The candidates are not added to the corpus.
+func (fi *FuzzInt) Minimize(c Case, key string) (Case, string) {
+	fi.minimizing = true
+	defer func() { fi.minimizing = false }()
+	finding, ok := fi.reproduces(c, key)
+	if !ok {
+		return c, ""
+	}
+	try := func(candidate Case) bool {
+		f, ok := fi.reproduces(candidate, key)
+		if ok {
+			c, finding = candidate, f
+		}
+		return ok
+	}
+
+	for i := len(c.Seq) - 2; i >= 0; i-- {
+		shorter := c.clone()
+		shorter.Seq = append(shorter.Seq[:i], shorter.Seq[i+1:]...)
+		try(shorter)
+	}
+	for _, loc := range sortedSlots(c.Storage) {
+		reset := c.clone()
+		delete(reset.Storage, loc)
+		try(reset)
+	}
+	for _, loc := range sortedSlots(c.Storage) {
+		shrinkWord(c.Storage[loc], func(v *big.Int) bool {
+			candidate := c.clone()
+			candidate.Storage[loc] = v
+			return try(candidate)
+		})
+	}
+	for i := range c.Seq {
+		for off := 4; off+32 <= len(c.Seq[i].Calldata); off += 32 {
+			shrinkWord(new(big.Int).SetBytes(c.Seq[i].Calldata[off:off+32]), func(v *big.Int) bool {
+				candidate := c.clone()
+				copy(candidate.Seq[i].Calldata[off:off+32], common.LeftPadBytes(v.Bytes(), 32))
+				return try(candidate)
+			})
+		}
+	}
+	return c, finding
+}
+
+//shrinkWord offers try the boundary values simpler than v, then halves of v,
+//until try keeps one
+func shrinkWord(v *big.Int, try func(*big.Int) bool) {
+	for _, candidate := range interestingWords {
+		if candidate.Cmp(v) == 0 {
+			return
+		}
+		if try(new(big.Int).Set(candidate)) {
+			return
+		}
+	}
+	for half := new(big.Int).Rsh(v, 1); half.Sign() > 0 && try(half); half = new(big.Int).Rsh(half, 1) {
+	}
+}
+
+func sortedSlots(storage map[common.Hash]*big.Int) []common.Hash {
+	slots := make([]common.Hash, 0, len(storage))
+	for loc := range storage {
+		slots = append(slots, loc)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Big().Cmp(slots[j].Big()) < 0 })
+	return slots
+}
+
+//GenCaseTable writes the storage mutations and calls of c
+func (fi *FuzzInt) GenCaseTable(c Case, writer io.Writer) {
+	table := tablewriter.NewWriter(writer)
+	table.SetHeader([]string{"Type", "Name", "Value"})
+	for _, name := range fi.constantsName {
+		if value, ok := c.Storage[fi.constantsLoc[name]]; ok {
+			table.Append([]string{"Storage", name, value.String()})
+		}
+	}
+	for _, tx := range c.Seq {
+		table.Append([]string{"Method", fmt.Sprintf("%s from %x", tx.Method.Sig(), tx.Sender[:4]), common.ToHex(tx.Calldata)})
+	}
+	table.SetAutoMergeCells(true)
+	table.Render()
+}
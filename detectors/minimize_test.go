@@ -0,0 +1,45 @@
+package detectors
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestMinimize(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	arm, fire := call(t, fi, "arm"), call(t, fi, "fire", big.NewInt(12345))
+	c := Case{Seq: Sequence{fire, arm, arm, fire}}
+	_, _, key := fi.runCase(c)
+	size := fi.corpus.Size()
+
+	min, finding := fi.Minimize(c, key)
+	if finding == "" {
+		t.Fatal("case does not reproduce")
+	}
+	if len(min.Seq) != 2 || min.Seq[0].Method.Name != "arm" || min.Seq[1].Method.Name != "fire" {
+		t.Fatalf("not minimal: %v", min.Seq)
+	}
+	if x := new(big.Int).SetBytes(min.Seq[1].Calldata[4:]); x.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("argument not shrunk: %v", x)
+	}
+	if fi.corpus.Size() != size {
+		t.Errorf("minimizing grew the corpus from %d to %d", size, fi.corpus.Size())
+	}
+	if new(big.Int).SetBytes(c.Seq[3].Calldata[4:]).Int64() != 12345 {
+		t.Error("minimizing modified the case")
+	}
+}
+
+func TestLogCaseUnreproduced(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	var buf bytes.Buffer
+	fi.logCase(&buf, Case{Seq: Sequence{call(t, fi, "fire", big.NewInt(1))}}, "Overflow: gone", "key")
+	if !strings.HasPrefix(buf.String(), "Unreproduced Overflow: gone") {
+		t.Fatalf("unreproduced finding not labeled:\n%s", buf.String())
+	}
+	if fi.corpus.Size() != 0 {
+		t.Fatal("unreproduced case kept")
+	}
+}
@@ -0,0 +1,509 @@
+package detectors
+
+import (
+	"fmt"
+	"math/big"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/vm"
+)
+
+var (
+	maxInt256 = new(big.Int).Sub(math.BigPow(2, 255), common.Big1)
+	minInt256 = new(big.Int).Neg(math.BigPow(2, 255))
+)
+
+//ArithmeticBug is an integer overflow or underflow seen during execution
+type ArithmeticBug struct {
+	Op       vm.OpCode
+	Pc       uint64
+	Depth    int
+	Address  common.Address
+	CodeHash common.Hash
+	Args     []*big.Int
+	Kind     string // "overflow", "shift overflow", "underflow", "signed overflow", "truncation" or "signed truncation"
+	Bits     int    // effective width of the type, less than 256 for truncations
+	Signed   bool   // the operands were treated as two's complement
+	Reverted bool   // the call frame it happened in was reverted
+	Benign   string // why the wrap is intended, "" if it may be exploitable
+
+	retracted bool // an unsigned wrap whose values turned out to be signed
+}
+
+func (b ArithmeticBug) String() string {
+	s := fmt.Sprintf("%s %s (%d bits) @PC = %d depth %d code %x args %v", b.Op, b.Kind, b.Bits, b.Pc, b.Depth, b.CodeHash[:4], b.Args)
+	if b.Benign != "" {
+		s += " benign: " + b.Benign
+	}
+	return s
+}
+
+//opInvalid is the designated invalid instruction solc compiles assert failures to
+const opInvalid = vm.OpCode(0xfe)
+
+const (
+	benignChecked     = "result checked by a comparison"
+	benignStorageSlot = "storage slot computation"
+)
+
+//signedCandidate is a signed wraparound of ADD, SUB or MUL, which only counts
+//once one of its values shows up in a signed context
+type signedCandidate struct {
+	bug      ArithmeticBug
+	values   []common.Hash // operands and result
+	promoted bool
+}
+
+//unsignedCandidate is an unsigned wraparound of ADD, SUB or MUL. It counts
+//unless one of its values shows up in a signed context, where adding a negative
+//number wraps by design.
+type unsignedCandidate struct {
+	bug    int           // index in bugs
+	values []common.Hash // operands and result
+}
+
+//pendingCheck is a comparison of wrapped results to their operands. The wraps
+//are benign if the JUMPI it feeds sends the execution to a revert, a check
+//that lets the wrap through leaves them live.
+type pendingCheck struct {
+	depth    int
+	codeHash common.Hash
+	bugs     []int // indexes in bugs
+}
+
+//arithmeticResult is a value pushed by ADD, SUB, MUL or EXP
+type arithmeticResult struct {
+	ctx  vm.OpContext
+	args []*big.Int
+}
+
+//frameMark remembers where an open call frame started recording
+type frameMark struct {
+	bugs, candidates int
+}
+
+//ArithmeticOracle is an evm inspector recording every arithmetic bug of an execution.
+//Signedness is inferred per execution: values produced or consumed by SIGNEXTEND,
+//SLT, SGT, SDIV and SMOD are signed, and so are the operations they came from.
+//Unsigned wraps of signed operations are retracted.
+//A truncated wrap (uint8 a - b underflowing) is reported once, with the narrow width.
+//Narrow types are checked where their results get cut down: an ADD, SUB, MUL or
+//EXP result losing bits to an AND mask or SIGNEXTEND is reported as a truncation.
+//Shifts compile to MUL by EXP(2, n), their overflows are reported as shift overflows.
+//Wraps whose result is compared to one of its operands by a JUMPI that then
+//branches to a REVERT or INVALID (SafeMath style post-checks catching them) or
+//used as a storage key (mapping and array slots) are marked benign.
+type ArithmeticOracle struct {
+	vm.NoopInspector
+	bugs       []ArithmeticBug
+	candidates []signedCandidate
+	unsigned   []unsignedCandidate
+	signed     map[common.Hash]bool
+	results    map[common.Hash]arithmeticResult
+	wrapped    map[common.Hash][]int // wrapped result -> indexes of its bugs
+	frames     []frameMark
+	checks     []pendingCheck
+}
+
+//NewArithmeticOracle creates an empty oracle, register it with evm.AddInspector
+func NewArithmeticOracle() *ArithmeticOracle {
+	o := &ArithmeticOracle{}
+	o.Reset()
+	return o
+}
+
+func newArithmeticBug(ctx vm.OpContext, args []*big.Int, kind string, signed bool) ArithmeticBug {
+	return ArithmeticBug{
+		Op:       ctx.Op,
+		Pc:       ctx.Pc,
+		Depth:    ctx.Depth,
+		Address:  ctx.Address,
+		CodeHash: ctx.CodeHash,
+		Args:     args,
+		Kind:     kind,
+		Bits:     256,
+		Signed:   signed,
+	}
+}
+
+//Arithmetic implements vm.Inspector
+func (o *ArithmeticOracle) Arithmetic(ctx vm.OpContext, args []*big.Int, result *big.Int) {
+	switch ctx.Op {
+	case vm.SDIV, vm.SMOD:
+		o.markSigned(args[0], args[1], result)
+	case vm.SIGNEXTEND:
+		o.markSigned(args[1], result)
+		if args[0].Cmp(big.NewInt(31)) < 0 {
+			o.checkTruncation(args[1], result, int(args[0].Uint64()+1)*8, true)
+		}
+		return
+	case vm.AND:
+		o.checkMask(args[0], args[1])
+		return
+	case vm.ADD, vm.SUB, vm.MUL, vm.EXP:
+		o.results[common.BigToHash(result)] = arithmeticResult{ctx, args}
+	}
+	values := []common.Hash{common.BigToHash(args[0]), common.BigToHash(args[1]), common.BigToHash(result)}
+	signed := false
+	for _, v := range values[:2] {
+		signed = signed || o.signed[v]
+	}
+	if kind := arithmeticBugKind(ctx.Op, args); kind != "" && (ctx.Op == vm.EXP || !signed) {
+		if ctx.Op == vm.MUL && (o.isShift(args[0]) || o.isShift(args[1])) {
+			kind = "shift overflow"
+		}
+		if ctx.Op != vm.EXP {
+			o.unsigned = append(o.unsigned, unsignedCandidate{bug: len(o.bugs), values: values})
+		}
+		o.recordWrap(newArithmeticBug(ctx, args, kind, false), values[2])
+	}
+	kind := signedBugKind(ctx.Op, args)
+	if kind == "" {
+		return
+	}
+	bug := newArithmeticBug(ctx, args, kind, true)
+	if ctx.Op == vm.SDIV || signed || o.signed[values[2]] {
+		o.recordWrap(bug, values[2])
+		return
+	}
+	o.candidates = append(o.candidates, signedCandidate{bug: bug, values: values})
+}
+
+//isShift reports whether value was pushed by EXP(2, n)
+func (o *ArithmeticOracle) isShift(value *big.Int) bool {
+	res, ok := o.results[common.BigToHash(value)]
+	return ok && res.ctx.Op == vm.EXP && res.args[0].Cmp(common.Big2) == 0
+}
+
+//checkMask checks an AND of x and y for an arithmetic result losing bits to a
+//uint8..uint248 mask
+func (o *ArithmeticOracle) checkMask(x, y *big.Int) {
+	for _, pair := range [][2]*big.Int{{x, y}, {y, x}} {
+		if bits := maskBits(pair[1]); bits > 0 {
+			o.checkTruncation(pair[0], new(big.Int).And(pair[0], pair[1]), bits, false)
+		}
+	}
+}
+
+//checkTruncation records a truncation if value is an arithmetic result that
+//narrowing to bits changed into narrowed
+func (o *ArithmeticOracle) checkTruncation(value, narrowed *big.Int, bits int, signed bool) {
+	if bits >= 256 || value.Cmp(narrowed) == 0 {
+		return
+	}
+	res, ok := o.results[common.BigToHash(value)]
+	if !ok {
+		return
+	}
+	// the wrap of the operation itself already covers it, only narrower
+	deduped := false
+	for _, i := range o.wrapped[common.BigToHash(value)] {
+		if b := &o.bugs[i]; !b.retracted && b.Signed == signed && b.Pc == res.ctx.Pc && b.CodeHash == res.ctx.CodeHash {
+			b.Bits, deduped = bits, true
+		}
+	}
+	if deduped {
+		return
+	}
+	kind := "truncation"
+	if signed {
+		kind = "signed truncation"
+	}
+	bug := newArithmeticBug(res.ctx, res.args, kind, signed)
+	bug.Bits = bits
+	o.bugs = append(o.bugs, bug)
+}
+
+//maskBits returns k if mask is 2^k-1 for a byte aligned k below 256, 0 otherwise
+func maskBits(mask *big.Int) int {
+	bits := mask.BitLen()
+	if bits == 0 || bits >= 256 || bits%8 != 0 {
+		return 0
+	}
+	for i := 0; i < bits; i++ {
+		if mask.Bit(i) == 0 {
+			return 0
+		}
+	}
+	return bits
+}
+
+//recordWrap records a wraparound bug whose wrapped value is result
+func (o *ArithmeticOracle) recordWrap(bug ArithmeticBug, result common.Hash) {
+	o.wrapped[result] = append(o.wrapped[result], len(o.bugs))
+	o.bugs = append(o.bugs, bug)
+}
+
+//wraps returns the indexes of the wraps that produced value and are not
+//classified yet. If operand is not nil, only wraps that had it as an operand are.
+func (o *ArithmeticOracle) wraps(value common.Hash, operand *big.Int) []int {
+	var bugs []int
+	for _, i := range o.wrapped[value] {
+		if o.bugs[i].Benign != "" || (operand != nil && !hasOperand(o.bugs[i], operand)) {
+			continue
+		}
+		bugs = append(bugs, i)
+	}
+	return bugs
+}
+
+//markBenign classifies the bugs as intended
+func (o *ArithmeticOracle) markBenign(bugs []int, reason string) {
+	for _, i := range bugs {
+		if o.bugs[i].Benign == "" {
+			o.bugs[i].Benign = reason
+		}
+	}
+}
+
+func hasOperand(bug ArithmeticBug, operand *big.Int) bool {
+	for _, arg := range bug.Args {
+		if arg.Cmp(operand) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//Comparison implements vm.Inspector
+func (o *ArithmeticOracle) Comparison(ctx vm.OpContext, args []*big.Int, result *big.Int) {
+	if ctx.Op == vm.SLT || ctx.Op == vm.SGT {
+		o.markSigned(args[0], args[1])
+	}
+	// A post-check compares the result against one of its operands,
+	// e.g. SafeMath's c >= a, and reverts if it fails.
+	bugs := append(o.wraps(common.BigToHash(args[0]), args[1]), o.wraps(common.BigToHash(args[1]), args[0])...)
+	if len(bugs) > 0 {
+		o.checks = append(o.checks, pendingCheck{ctx.Depth, ctx.CodeHash, bugs})
+	}
+}
+
+//Jump implements vm.Inspector, the first JUMPI after a pending check decides it.
+//The wraps happened, so a check catching them sends this execution to the revert.
+func (o *ArithmeticOracle) Jump(ctx vm.OpContext, dest uint64, taken bool) {
+	if ctx.Op != vm.JUMPI || len(o.checks) == 0 {
+		return
+	}
+	next := ctx.Pc + 1
+	if taken {
+		next = dest
+	}
+	caught := reverts(ctx.Code, next)
+	checks := o.checks[:0]
+	for _, c := range o.checks {
+		if c.depth != ctx.Depth || c.codeHash != ctx.CodeHash {
+			checks = append(checks, c)
+		} else if caught {
+			o.markBenign(c.bugs, benignChecked)
+		}
+	}
+	o.checks = checks
+}
+
+//reverts reports whether executing code from pc ends in REVERT or INVALID without
+//side effects or conditional jumps. Static jumps, like solc's jump to a panic
+//function, are followed.
+func reverts(code []byte, pc uint64) bool {
+	var pushed *big.Int
+	for n := 0; n < 64 && pc < uint64(len(code)); n++ {
+		op := vm.OpCode(code[pc])
+		switch {
+		case op == vm.REVERT || op == opInvalid:
+			return true
+		case op.IsPush():
+			size := uint64(op-vm.PUSH1) + 1
+			pushed = new(big.Int).SetBytes(code[pc+1 : minUint64(pc+1+size, uint64(len(code)))])
+			pc += size + 1
+			continue
+		case op == vm.JUMP:
+			if pushed == nil || !pushed.IsUint64() || pushed.Uint64() >= uint64(len(code)) || vm.OpCode(code[pushed.Uint64()]) != vm.JUMPDEST {
+				return false
+			}
+			pc, pushed = pushed.Uint64(), nil
+			continue
+		case op == vm.JUMPI || op == vm.STOP || op == vm.RETURN || op == vm.SSTORE || op == vm.SELFDESTRUCT,
+			op >= vm.LOG0 && op <= vm.LOG4, op >= vm.CREATE && op <= vm.STATICCALL:
+			return false
+		}
+		pushed = nil
+		pc++
+	}
+	return false
+}
+
+func minUint64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+//StorageRead implements vm.Inspector
+func (o *ArithmeticOracle) StorageRead(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	o.markBenign(o.wraps(key, nil), benignStorageSlot)
+}
+
+//StorageWrite implements vm.Inspector
+func (o *ArithmeticOracle) StorageWrite(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	o.markBenign(o.wraps(key, nil), benignStorageSlot)
+}
+
+//markSigned records values as signed and promotes the candidates involving them.
+//0, 1 and -1 are too common to tell anything and are skipped.
+func (o *ArithmeticOracle) markSigned(values ...*big.Int) {
+	for _, value := range values {
+		if new(big.Int).Abs(math.S256(new(big.Int).Set(value))).Cmp(common.Big1) <= 0 {
+			continue
+		}
+		v := common.BigToHash(value)
+		if o.signed[v] {
+			continue
+		}
+		o.signed[v] = true
+		for _, c := range o.unsigned {
+			for _, cv := range c.values {
+				if cv == v {
+					o.bugs[c.bug].retracted = true
+					break
+				}
+			}
+		}
+		for i := range o.candidates {
+			c := &o.candidates[i]
+			if c.promoted {
+				continue
+			}
+			for _, cv := range c.values {
+				if cv == v {
+					c.promoted = true
+					o.recordWrap(c.bug, c.values[2])
+					break
+				}
+			}
+		}
+	}
+}
+
+//CallEnter implements vm.Inspector
+func (o *ArithmeticOracle) CallEnter(ctx vm.OpContext, to common.Address, input []byte, gas uint64, value *big.Int) {
+	o.frames = append(o.frames, frameMark{len(o.bugs), len(o.candidates)})
+}
+
+//CallExit implements vm.Inspector, bugs of a failed call frame are marked reverted
+func (o *ArithmeticOracle) CallExit(ctx vm.OpContext, ret []byte, err error) {
+	if len(o.frames) == 0 {
+		return
+	}
+	mark := o.frames[len(o.frames)-1]
+	o.frames = o.frames[:len(o.frames)-1]
+	// checks of the callee can't be decided by a jump of the caller
+	checks := o.checks[:0]
+	for _, c := range o.checks {
+		if c.depth <= ctx.Depth {
+			checks = append(checks, c)
+		}
+	}
+	o.checks = checks
+	if err != nil {
+		for i := mark.bugs; i < len(o.bugs); i++ {
+			o.bugs[i].Reverted = true
+		}
+		for i := mark.candidates; i < len(o.candidates); i++ {
+			o.candidates[i].bug.Reverted = true
+		}
+	}
+}
+
+//Reset forgets everything recorded, call it before each execution
+func (o *ArithmeticOracle) Reset() {
+	o.bugs = nil
+	o.candidates = nil
+	o.unsigned = nil
+	o.signed = make(map[common.Hash]bool)
+	o.results = make(map[common.Hash]arithmeticResult)
+	o.wrapped = make(map[common.Hash][]int)
+	o.frames = nil
+	o.checks = nil
+}
+
+//Bugs returns all bugs recorded since the last Reset, in execution order
+func (o *ArithmeticOracle) Bugs() []ArithmeticBug {
+	var bugs []ArithmeticBug
+	for _, bug := range o.bugs {
+		if !bug.retracted {
+			bugs = append(bugs, bug)
+		}
+	}
+	return bugs
+}
+
+//Live returns the bugs recorded outside of reverted call frames that are not benign
+func (o *ArithmeticOracle) Live() []ArithmeticBug {
+	var live []ArithmeticBug
+	for _, bug := range o.Bugs() {
+		if !bug.Reverted && bug.Benign == "" {
+			live = append(live, bug)
+		}
+	}
+	return live
+}
+
+//Found reports whether a bug outside of reverted call frames that is not benign was recorded
+func (o *ArithmeticOracle) Found() bool {
+	return len(o.Live()) > 0
+}
+
+//arithmeticBugKind returns the kind of unsigned bug op has with args, or "" if the result is exact
+func arithmeticBugKind(op vm.OpCode, args []*big.Int) string {
+	switch op {
+	case vm.ADD:
+		if new(big.Int).Add(args[0], args[1]).Cmp(math.MaxBig256) > 0 {
+			return "overflow"
+		}
+	case vm.SUB:
+		if args[0].Cmp(args[1]) < 0 {
+			return "underflow"
+		}
+	case vm.MUL:
+		if new(big.Int).Mul(args[0], args[1]).Cmp(math.MaxBig256) > 0 {
+			return "overflow"
+		}
+	case vm.EXP:
+		base, exponent := args[0], args[1]
+		if base.Cmp(common.Big1) <= 0 || exponent.Sign() == 0 {
+			return ""
+		}
+		// Any base above 1 wraps past 2^256 after 256 doublings.
+		if exponent.Cmp(big.NewInt(256)) >= 0 || new(big.Int).Exp(base, exponent, nil).Cmp(math.MaxBig256) > 0 {
+			return "overflow"
+		}
+	}
+	return ""
+}
+
+//signedBugKind returns the kind of signed bug op has with args, or "" if the
+//two's complement result is exact
+func signedBugKind(op vm.OpCode, args []*big.Int) string {
+	switch op {
+	case vm.ADD, vm.SUB, vm.MUL:
+		x, y := math.S256(new(big.Int).Set(args[0])), math.S256(new(big.Int).Set(args[1]))
+		r := new(big.Int)
+		switch op {
+		case vm.ADD:
+			r.Add(x, y)
+		case vm.SUB:
+			r.Sub(x, y)
+		case vm.MUL:
+			r.Mul(x, y)
+		}
+		if r.Cmp(maxInt256) > 0 || r.Cmp(minInt256) < 0 {
+			return "signed overflow"
+		}
+	case vm.SDIV:
+		x, y := math.S256(new(big.Int).Set(args[0])), math.S256(new(big.Int).Set(args[1]))
+		if x.Cmp(minInt256) == 0 && y.Cmp(big.NewInt(-1)) == 0 {
+			return "signed overflow"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core"
+	"minievm/core/state"
+	"minievm/core/vm"
+	"minievm/params"
+	"testing"
+)
+
+var (
+	testCaller = common.HexToAddress("0xca11e4")
+	testCode   = common.HexToAddress("0xc0de")
+)
+
+//opcodes vm declares untyped, asm would push them as ints
+const (
+	opSHA3   = vm.OpCode(vm.SHA3)
+	opREVERT = vm.OpCode(vm.REVERT)
+)
+
+//label marks a JUMPDEST in asm, ref pushes its offset
+type label string
+type ref string
+
+//asm assembles opcodes, PUSHes of ints and *big.Ints (two's complement for
+//negative values), labels and refs to them into bytecode
+func asm(items ...interface{}) []byte {
+	size := func(item interface{}) int {
+		switch v := item.(type) {
+		case int:
+			return 1 + len(pushBytes(big.NewInt(int64(v))))
+		case *big.Int:
+			return 1 + len(pushBytes(v))
+		case ref:
+			return 3
+		}
+		return 1
+	}
+	labels := make(map[label]int)
+	pc := 0
+	for _, item := range items {
+		if l, ok := item.(label); ok {
+			labels[l] = pc
+		}
+		pc += size(item)
+	}
+	var code []byte
+	for _, item := range items {
+		switch v := item.(type) {
+		case vm.OpCode:
+			code = append(code, byte(v))
+		case int:
+			b := pushBytes(big.NewInt(int64(v)))
+			code = append(append(code, byte(vm.PUSH1)+byte(len(b)-1)), b...)
+		case *big.Int:
+			b := pushBytes(v)
+			code = append(append(code, byte(vm.PUSH1)+byte(len(b)-1)), b...)
+		case label:
+			code = append(code, byte(vm.JUMPDEST))
+		case ref:
+			code = append(code, byte(vm.PUSH2), byte(labels[label(v)]>>8), byte(labels[label(v)]))
+		}
+	}
+	return code
+}
+
+func pushBytes(v *big.Int) []byte {
+	b := math.U256(new(big.Int).Set(v)).Bytes()
+	if len(b) == 0 {
+		return []byte{0}
+	}
+	return b
+}
+
+//runCode executes code with input under the inspectors and returns the call's error
+func runCode(t *testing.T, code, input []byte, inspectors ...vm.Inspector) error {
+	st := state.New()
+	st.SetCode(testCode, code)
+	evm := vm.NewEVM(vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		BlockNumber: big.NewInt(4370001),
+		Time:        big.NewInt(blocktime),
+		GasPrice:    big.NewInt(1),
+		Difficulty:  big.NewInt(1),
+	}, st, params.MainnetChainConfig, vm.Config{})
+	for _, i := range inspectors {
+		evm.AddInspector(i)
+	}
+	_, _, err := evm.Call(vm.AccountRef(testCaller), testCode, input, 10000000, new(big.Int))
+	return err
+}
+
+//kinds returns the kinds of bugs
+func kinds(bugs []ArithmeticBug) []string {
+	var k []string
+	for _, bug := range bugs {
+		k = append(k, bug.Kind)
+	}
+	return k
+}
+
+func sameKinds(have, want []string) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestArithmeticOracle(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		live []string
+	}{
+		{"add exact", asm(2, 3, vm.ADD, vm.STOP), nil},
+		{"add overflow", asm(1, math.MaxBig256, vm.ADD, vm.STOP), []string{"overflow"}},
+		{"sub underflow", asm(5, 3, vm.SUB, vm.STOP), []string{"underflow"}},
+		{"sub exact", asm(3, 5, vm.SUB, vm.STOP), nil},
+		{"mul overflow", asm(2, math.BigPow(2, 255), vm.MUL, vm.STOP), []string{"overflow"}},
+		{"exp overflow", asm(256, 10, vm.EXP, vm.STOP), []string{"overflow"}},
+		{"reverted", asm(1, math.MaxBig256, vm.ADD, 0, 0, opREVERT), nil},
+		// int x + (-3) and 0 - 5, compared as signed afterwards
+		{"signed add negative", asm(big.NewInt(-3), 5, vm.ADD, 0, vm.SLT, vm.STOP), nil},
+		{"signed sub negative", asm(5, 0, vm.SUB, 0, vm.SLT, vm.STOP), nil},
+		{"signed operand", asm(big.NewInt(-3), 0, vm.SLT, vm.POP, big.NewInt(-3), 5, vm.ADD, vm.STOP), nil},
+		{"signed overflow", asm(1, maxInt256, vm.ADD, 0, vm.SLT, vm.STOP), []string{"signed overflow"}},
+		// uint8 3 - 5 is one underflow, not an underflow and a truncation
+		{"uint8 underflow", asm(0xff, 5, 3, vm.SUB, vm.AND, vm.STOP), []string{"underflow"}},
+		{"uint8 truncation", asm(0xff, 200, 100, vm.ADD, vm.AND, vm.STOP), []string{"truncation"}},
+	}
+	for _, test := range tests {
+		o := NewArithmeticOracle()
+		runCode(t, test.code, nil, o)
+		if live := kinds(o.Live()); !sameKinds(live, test.live) {
+			t.Errorf("%s: live bugs mismatch: have %v, want %v", test.name, live, test.live)
+		}
+	}
+}
+
+func TestNarrowUnderflowBits(t *testing.T) {
+	o := NewArithmeticOracle()
+	runCode(t, asm(0xff, 5, 3, vm.SUB, vm.AND, vm.STOP), nil, o)
+	if bugs := o.Bugs(); len(bugs) != 1 || bugs[0].Bits != 8 {
+		t.Fatalf("want one 8 bit underflow, have %v", bugs)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		live []string
+		bits int
+	}{
+		{"uint8 fits", asm(0xff, 100, 100, vm.ADD, vm.AND, vm.STOP), nil, 0},
+		{"uint8 add", asm(0xff, 200, 100, vm.ADD, vm.AND, vm.STOP), []string{"truncation"}, 8},
+		{"uint16 mul", asm(0xffff, 300, 300, vm.MUL, vm.AND, vm.STOP), []string{"truncation"}, 16},
+		{"int8 add", asm(100, 100, vm.ADD, 0, vm.SIGNEXTEND, vm.STOP), []string{"signed truncation"}, 8},
+		{"int8 fits", asm(20, 100, vm.ADD, 0, vm.SIGNEXTEND, vm.STOP), nil, 0},
+		{"not arithmetic", asm(0xff, 0x1ff, vm.AND, vm.STOP), nil, 0},
+		{"not a mask", asm(0xf0, 200, 100, vm.ADD, vm.AND, vm.STOP), nil, 0},
+	}
+	for _, test := range tests {
+		o := NewArithmeticOracle()
+		runCode(t, test.code, nil, o)
+		live := o.Live()
+		if !sameKinds(kinds(live), test.live) {
+			t.Errorf("%s: live bugs mismatch: have %v, want %v", test.name, kinds(live), test.live)
+			continue
+		}
+		if len(live) > 0 && live[0].Bits != test.bits {
+			t.Errorf("%s: bits mismatch: have %d, want %d", test.name, live[0].Bits, test.bits)
+		}
+	}
+}
+
+func TestExpOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		live []string
+	}{
+		{"exp exact", asm(255, 2, vm.EXP, vm.STOP), nil},
+		{"exp base one", asm(1000, 1, vm.EXP, vm.STOP), nil},
+		{"exp zero exponent", asm(0, math.MaxBig256, vm.EXP, vm.STOP), nil},
+		{"exp wraps", asm(78, 10, vm.EXP, vm.STOP), []string{"overflow"}},
+		// x << 200 compiles to x * 2**200
+		{"shift exact", asm(200, 2, vm.EXP, 3, vm.MUL, vm.STOP), nil},
+		{"shift overflow", asm(200, 2, vm.EXP, math.BigPow(2, 100), vm.MUL, vm.STOP), []string{"shift overflow"}},
+		{"mul overflow", asm(math.BigPow(2, 200), math.BigPow(2, 100), vm.MUL, vm.STOP), []string{"overflow"}},
+	}
+	for _, test := range tests {
+		o := NewArithmeticOracle()
+		runCode(t, test.code, nil, o)
+		if live := kinds(o.Live()); !sameKinds(live, test.live) {
+			t.Errorf("%s: live bugs mismatch: have %v, want %v", test.name, live, test.live)
+		}
+	}
+}
+
+func TestBenignChecks(t *testing.T) {
+	// r = 1 + max; then r < max, true as the add wrapped
+	wrapped := []interface{}{1, math.MaxBig256, vm.ADD, math.MaxBig256, vm.DUP2, vm.LT}
+	code := func(tail ...interface{}) []byte {
+		return asm(append(append([]interface{}{}, wrapped...), tail...)...)
+	}
+	tests := []struct {
+		name   string
+		code   []byte
+		benign string
+		live   []string
+	}{
+		{"require", code(ref("fail"), vm.JUMPI, vm.STOP, label("fail"), 0, 0, opREVERT), benignChecked, nil},
+		{"assert", code(vm.ISZERO, ref("ok"), vm.JUMPI, opInvalid, label("ok"), vm.STOP), benignChecked, nil},
+		{"panic function", code(vm.ISZERO, ref("ok"), vm.JUMPI, ref("panic"), vm.JUMP, label("ok"), vm.STOP, label("panic"), 0x11, 0, vm.MSTORE, 0x24, 0, opREVERT), benignChecked, nil},
+		{"branch", code(ref("fail"), vm.JUMPI, vm.STOP, label("fail"), 1, 5, vm.SSTORE, vm.STOP), "", []string{"overflow"}},
+		// c = 3 * 2^255 wraps to 2^255, c >= 3 holds and skips the INVALID
+		{"wrong check", asm(3, math.BigPow(2, 255), vm.MUL, 3, vm.DUP2, vm.LT, vm.ISZERO, ref("ok"), vm.JUMPI, opInvalid, label("ok"), vm.STOP), "", []string{"overflow"}},
+		{"unchecked", code(vm.STOP), "", []string{"overflow"}},
+		{"other operand", asm(1, math.MaxBig256, vm.ADD, 7, vm.DUP2, vm.LT, ref("fail"), vm.JUMPI, vm.STOP, label("fail"), 0, 0, opREVERT), "", nil},
+		{"storage slot", asm(1, math.MaxBig256, vm.ADD, vm.SLOAD, vm.STOP), benignStorageSlot, nil},
+	}
+	for _, test := range tests {
+		o := NewArithmeticOracle()
+		runCode(t, test.code, nil, o)
+		if bugs := o.Bugs(); len(bugs) != 1 || bugs[0].Benign != test.benign {
+			t.Errorf("%s: bugs mismatch: have %v, want one %q", test.name, bugs, test.benign)
+		}
+		if live := kinds(o.Live()); !sameKinds(live, test.live) {
+			t.Errorf("%s: live bugs mismatch: have %v, want %v", test.name, live, test.live)
+		}
+	}
+}
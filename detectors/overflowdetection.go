@@ -12,7 +12,6 @@ import (
 	"minievm/params"
 	"strconv"
 	"strings"
-	"time"
 )
 
 /*
@@ -54,7 +53,7 @@ func (ofd *OverFlowDetector) Init(contractName, abijson string) {
 	ofd.contractName = contractNameSlice[len(contractNameSlice)-1]
 
 	log.SetPrefix(ofd.contractName + " ")
-	ofd.state = state.New()
+	ofd.state = state.NewWithConfig(state.Config{AutoFund: AutoFund})
 	ofd.InitExternalAccount()
 	abi, err := abi.JSON(strings.NewReader(abijson))
 	if err != nil {
@@ -67,18 +66,18 @@ func (ofd *OverFlowDetector) Init(contractName, abijson string) {
 		Transfer:    core.Transfer,
 		CanTransfer: core.CanTransfer,
 		BlockNumber: big.NewInt(4370001),
-		Time:        big.NewInt(time.Now().Unix()),
+		Time:        big.NewInt(blocktime),
 		GetHash:     func(in uint64) common.Hash { return common.BigToHash(big.NewInt(int64(in))) },
 		GasPrice:    big.NewInt(100),
 		Difficulty:  big.NewInt(100),
 	}
 	ofd.evm = vm.NewEVM(*ofd.context, ofd.state, params.MainnetChainConfig, vm.Config{EnableJit: false, ForceJit: false, Debug: false})
-	ofd.evm.Callback = NULLCallback
 }
 
 // CreateContract creates a new contract
 func (ofd *OverFlowDetector) CreateContract(addr common.Address, code []byte, value *big.Int) (err error) {
 	_, caddr, _, err := ofd.evm.Create(vm.AccountRef(addr), code, uint64(100000000000), value)
+	ofd.state.Finalise(ofd.evm.ChainConfig().IsEIP158(ofd.evm.BlockNumber))
 	if err != nil {
 		return
 	}
@@ -86,8 +85,9 @@ func (ofd *OverFlowDetector) CreateContract(addr common.Address, code []byte, va
 	return
 }
 
-func (ofd *OverFlowDetector) SetCallback(cb vm.CallbackFunc) {
-	ofd.evm.Callback = cb
+//AddInspector registers an inspector on the detector's evm
+func (ofd *OverFlowDetector) AddInspector(i vm.Inspector) {
+	ofd.evm.AddInspector(i)
 }
 
 func (ofd *OverFlowDetector) GetState(key common.Hash) common.Hash {
@@ -101,6 +101,7 @@ func (ofd *OverFlowDetector) SetState(key, hash common.Hash) {
 // FunctionCall call from addr to contract
 func (ofd *OverFlowDetector) FunctionCall(from common.Address, to common.Address, input []byte, value *big.Int) (ret []byte, err error) {
 	ret, _, err = ofd.evm.Call(vm.AccountRef(from), to, input, uint64(100000000000), value)
+	ofd.state.Finalise(ofd.evm.ChainConfig().IsEIP158(ofd.evm.BlockNumber))
 	ofd.Executionresult = err
 	return
 }
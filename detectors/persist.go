@@ -0,0 +1,158 @@
+package detectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"minievm/accounts/abi"
+	"minievm/common"
+	"minievm/common/hexutil"
+	"minievm/crypto"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+type callJSON struct {
+	Sender   common.Address `json:"sender"`
+	Method   string         `json:"method"`
+	Calldata hexutil.Bytes  `json:"calldata"`
+}
+
+//caseJSON is the on-disk form of a Case, methods are stored by signature
+type caseJSON struct {
+	Storage map[common.Hash]*hexutil.Big `json:"storage,omitempty"`
+	Calls   []callJSON                   `json:"calls"`
+}
+
+func marshalCase(c Case) ([]byte, error) {
+	enc := caseJSON{Calls: make([]callJSON, len(c.Seq))}
+	if len(c.Storage) > 0 {
+		enc.Storage = make(map[common.Hash]*hexutil.Big, len(c.Storage))
+		for loc, value := range c.Storage {
+			enc.Storage[loc] = (*hexutil.Big)(value)
+		}
+	}
+	for i, tx := range c.Seq {
+		enc.Calls[i] = callJSON{tx.Sender, tx.Method.Sig(), tx.Calldata}
+	}
+	return json.MarshalIndent(enc, "", "  ")
+}
+
+func unmarshalCase(data []byte, methods map[string]abi.Method) (Case, error) {
+	var dec caseJSON
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return Case{}, err
+	}
+	c := Case{Storage: make(map[common.Hash]*big.Int, len(dec.Storage))}
+	for loc, value := range dec.Storage {
+		c.Storage[loc] = (*big.Int)(value)
+	}
+	for _, call := range dec.Calls {
+		method, ok := methods[call.Method]
+		if !ok {
+			return Case{}, fmt.Errorf("unknown method %s", call.Method)
+		}
+		c.Seq = append(c.Seq, Tx{call.Sender, method, call.Calldata})
+	}
+	return c, nil
+}
+
+//Open makes the corpus persistent in dir: the cases stored there by earlier
+//runs, possibly on other machines, are loaded, and cases added later are
+//written there. Cases calling methods not in methods are skipped.
+//The loaded cases steer the fuzzer, so a seed only replays a run on the same
+//corpus, see Hash.
+func (c *Corpus) Open(dir string, methods []abi.Method) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	bySig := make(map[string]abi.Method)
+	for _, method := range methods {
+		bySig[method.Sig()] = method
+	}
+	files, err := filepath.Glob(path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var loaded [][]byte
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		cs, err := unmarshalCase(data, bySig)
+		if err != nil || len(cs.Seq) == 0 {
+			continue
+		}
+		c.add(cs)
+		loaded = append(loaded, data)
+	}
+	c.mu.Lock()
+	c.dir = dir
+	c.loaded = crypto.Keccak256Hash(loaded...)
+	c.mu.Unlock()
+	return nil
+}
+
+//Hash identifies the cases Open loaded, in the order it loaded them. It is the
+//zero hash for a corpus that was not opened.
+func (c *Corpus) Hash() common.Hash {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loaded
+}
+
+//AddCase stores the last call of cs as an input of its method and cs as a
+//sequence if it has several calls. Persistent corpora also write it to disk,
+//named by its content so corpora of several machines can simply be merged.
+func (c *Corpus) AddCase(cs Case) error {
+	c.add(cs)
+	c.mu.RLock()
+	dir := c.dir
+	c.mu.RUnlock()
+	if dir == "" {
+		return nil
+	}
+	data, err := marshalCase(cs)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%x.json", crypto.Keccak256(data)[:8])
+	return ioutil.WriteFile(path.Join(dir, name), data, 0644)
+}
+
+func (c *Corpus) add(cs Case) {
+	last := cs.Seq[len(cs.Seq)-1]
+	c.Add(last.Method.Sig(), last.Calldata)
+	if len(cs.Seq) > 1 {
+		c.mu.Lock()
+		c.sequences = append(c.sequences, cs.clone().Seq)
+		c.mu.Unlock()
+	}
+}
+
+//Sequences returns copies of the stored call sequences
+func (c *Corpus) Sequences() []Sequence {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seqs := make([]Sequence, len(c.sequences))
+	for i, seq := range c.sequences {
+		seqs[i] = Case{Seq: seq}.clone().Seq
+	}
+	return seqs
+}
+
+//OpenCorpus makes the corpus persistent in a directory of dir named by the
+//code hash of the main contract, so runs on the same code share it
+func (fi *FuzzInt) OpenCorpus(dir string) error {
+	codehash := fi.contracts.CurrentState().GetCodeHash(fi.maincontract.Address)
+	return fi.corpus.Open(path.Join(dir, common.Bytes2Hex(codehash[:])), fi.methods())
+}
+
+//CorpusHash identifies the corpus OpenCorpus loaded, runs with the same seed
+//only match if they started from the same corpus
+func (fi *FuzzInt) CorpusHash() common.Hash {
+	return fi.corpus.Hash()
+}
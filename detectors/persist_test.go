@@ -0,0 +1,63 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/common"
+	"testing"
+)
+
+func TestCorpusPersistence(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	arm, fire := call(t, fi, "arm"), call(t, fi, "fire", big.NewInt(3))
+	dir := t.TempDir()
+
+	c := NewCorpus()
+	if err := c.Open(dir, fi.methods()); err != nil {
+		t.Fatal(err)
+	}
+	empty := c.Hash()
+	c.AddCase(Case{Seq: Sequence{arm, fire}})
+	c.AddCase(Case{Storage: map[common.Hash]*big.Int{{}: big.NewInt(9)}, Seq: Sequence{fire}})
+
+	resumed := NewCorpus()
+	if err := resumed.Open(dir, fi.methods()); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Size() != 2 || len(resumed.Inputs(fire.Method.Sig())) != 2 || len(resumed.Sequences()) != 1 {
+		t.Fatalf("resumed %d inputs and %d sequences, want 2 and 1", resumed.Size(), len(resumed.Sequences()))
+	}
+	if seq := resumed.Sequences()[0]; seq.String() != (Sequence{arm, fire}).String() {
+		t.Fatalf("sequence mismatch:\n%s", seq)
+	}
+
+	// the hash tells corpora a seed replays on apart
+	again := NewCorpus()
+	again.Open(dir, fi.methods())
+	if resumed.Hash() != again.Hash() || resumed.Hash() == empty || NewCorpus().Hash() != (common.Hash{}) {
+		t.Fatalf("corpus hashes mismatch: %x %x %x", resumed.Hash(), again.Hash(), empty)
+	}
+
+	// cases of other code are skipped
+	other := NewCorpus()
+	if err := other.Open(dir, nil); err != nil || other.Size() != 0 {
+		t.Fatalf("loaded %d cases of unknown methods: %v", other.Size(), err)
+	}
+}
+
+func TestCorpusCopies(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	arm, fire := call(t, fi, "arm"), call(t, fi, "fire", big.NewInt(3))
+	c := NewCorpus()
+	c.AddCase(Case{Seq: Sequence{arm, fire}})
+
+	c.Inputs(fire.Method.Sig())[0][4] = 0xff
+	seq := c.Sequences()[0]
+	seq[1].Calldata[4] = 0xff
+	seq[0] = fire
+	if c.Inputs(fire.Method.Sig())[0][4] != 0 {
+		t.Error("input modified through Inputs")
+	}
+	if seq := c.Sequences()[0]; seq[0].Method.Name != "arm" || seq[1].Calldata[4] != 0 {
+		t.Error("sequence modified through Sequences")
+	}
+}
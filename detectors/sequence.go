@@ -0,0 +1,209 @@
+package detectors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"minievm/accounts/abi"
+	"minievm/common"
+	"os"
+	"strings"
+)
+
+const (
+	MaxSequenceLength = 8
+	sendersNum        = 3
+)
+
+//Tx is a call to a method of the main contract
+type Tx struct {
+	Sender   common.Address
+	Method   abi.Method
+	Calldata []byte
+}
+
+//Sequence is a list of calls executed on the same state one after another
+type Sequence []Tx
+
+func (seq Sequence) String() string {
+	lines := make([]string, len(seq))
+	for i, tx := range seq {
+		lines[i] = fmt.Sprintf("%d. %x %s %s", i+1, tx.Sender[:4], tx.Method.Sig(), common.ToHex(tx.Calldata))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//senders returns the accounts sequences send calls from
+func (fi *FuzzInt) senders() []common.Address {
+	senders := []common.Address{fi.contracts.ContractCreater, fi.contracts.ContractAttacker}
+	for i := 0; i < sendersNum; i++ {
+		senders = append(senders, common.StringToAddress(fmt.Sprintf("Sequence sender %d", i)))
+	}
+	return senders
+}
+
+//newSequence generates a random sequence of calls from random senders, or
+//mutates one of the corpus half of the time
+func (fi *FuzzInt) newSequence(methods []abi.Method, senders []common.Address) Sequence {
+	if seqs := fi.corpus.Sequences(); len(seqs) > 0 && fi.rand.Intn(2) == 0 {
+		return fi.mutateSequence(seqs[fi.rand.Intn(len(seqs))], methods, senders)
+	}
+	seq := make(Sequence, 1+fi.rand.Intn(MaxSequenceLength))
+	for i := range seq {
+		method := methods[fi.rand.Intn(len(methods))]
+		seq[i] = Tx{
+			Sender:   senders[fi.rand.Intn(len(senders))],
+			Method:   method,
+			Calldata: fi.nextInput(method),
+		}
+	}
+	return seq
+}
+
+//mutateSequence returns a copy of seq with a call given a new input or sender,
+//or a random call appended
+func (fi *FuzzInt) mutateSequence(seq Sequence, methods []abi.Method, senders []common.Address) Sequence {
+	seq = Case{Seq: seq}.clone().Seq
+	tx := &seq[fi.rand.Intn(len(seq))]
+	switch fi.rand.Intn(3) {
+	case 0:
+		tx.Calldata = fi.nextInput(tx.Method)
+	case 1:
+		tx.Sender = senders[fi.rand.Intn(len(senders))]
+	case 2:
+		if len(seq) < MaxSequenceLength {
+			method := methods[fi.rand.Intn(len(methods))]
+			seq = append(seq, Tx{senders[fi.rand.Intn(len(senders))], method, fi.nextInput(method)})
+		}
+	}
+	return seq
+}
+
+//finding describes what the oracles found in the last call and identifies it
+//by kind and location, both are "" if nothing was found
+func (fi *FuzzInt) finding(err error) (string, string) {
+	if err != nil {
+		return "", ""
+	}
+	if fi.oracle.Found() {
+		bug := fi.oracle.Live()[0]
+		return "Overflow: " + bug.String(), fmt.Sprintf("%s %s %x@%d", bug.Op, bug.Kind, bug.CodeHash, bug.Pc)
+	}
+	if fi.divOracle.Found() {
+		z := fi.divOracle.Live()[0]
+		return "Division: " + z.String(), fmt.Sprintf("%s %x@%d", z.Op, z.CodeHash, z.Pc)
+	}
+	return "", ""
+}
+
+//runCase executes c on a fork of the deployed state and returns the index of
+//the first call with a finding, the finding and its key, or -1. Calls reaching
+//new edges are added to the corpus. Invariants and ERC20 properties are checked
+//after every call unless c mutates storage, which they are not expected to survive.
+func (fi *FuzzInt) runCase(c Case) (int, string, string) {
+	fi.forkCase(c)
+	defer fi.contracts.DiscardStates()
+
+	checkInvariants := len(c.Storage) == 0
+	if checkInvariants && fi.erc20 != nil {
+		fi.erc20.reset(fi.senders())
+	}
+	for i, tx := range c.Seq {
+		var before *erc20Before
+		if checkInvariants && fi.erc20 != nil {
+			before = fi.erc20.before(tx)
+		}
+		fi.oracle.Reset()
+		fi.divOracle.Reset()
+		fi.coverage.Reset()
+		logs := len(fi.contracts.CurrentState().Logs)
+		ret, _, err := fi.contracts.Call(tx.Sender, fi.maincontract.Address, tx.Calldata, uint64(100000000000), big.NewInt(0))
+		fi.contracts.Finalise()
+		if !fi.minimizing && fi.coverage.Merge() > 0 {
+			fi.keep(Case{Storage: c.Storage, Seq: c.Seq[:i+1]})
+		}
+		if finding, key := fi.finding(err); finding != "" {
+			return i, finding, key
+		}
+		if checkInvariants && fi.erc20 != nil {
+			if finding, key := fi.erc20.check(tx, before, fi.contracts.CurrentState().Logs[logs:], ret, err); finding != "" {
+				return i, finding, key
+			}
+		}
+		if checkInvariants && err == nil {
+			if finding, key := fi.brokenInvariant(); finding != "" {
+				return i, finding, key
+			}
+		}
+	}
+	return -1, "", ""
+}
+
+//forkCase forks the deployed state and sets the storage of c on the fork,
+//DiscardStates drops it
+func (fi *FuzzInt) forkCase(c Case) {
+	fi.contracts.ForkStates()
+	for loc, value := range fi.deployedStorage() {
+		if v, ok := c.Storage[loc]; ok {
+			value = v
+		}
+		fi.contracts.SetStorage(loc, value)
+	}
+}
+
+//FuzzSequences runs rounds random call sequences, so bugs that need several calls
+//(approve then transferFrom, setPrice then sell) are reachable. Findings are
+//minimized and appended to the log.
+func (fi *FuzzInt) FuzzSequences(rounds int) {
+	w, closeLog, err := fi.openLog(os.O_APPEND)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer closeLog()
+
+	fi.deployedStorage()
+	methods, senders := fi.methods(), fi.senders()
+	if len(methods) == 0 {
+		return
+	}
+	found := make(map[string]bool)
+	if finding, key := fi.deployedInvariant(); finding != "" && fi.claim(found, key) {
+		fmt.Fprintf(w, "%s on the deployed state\nSeed: %d\n", finding, fi.seed)
+	}
+	for round := 0; round < rounds; round++ {
+		seq := fi.newSequence(methods, senders)
+		fi.iteration++
+		n, finding, key := fi.runCase(Case{Seq: seq})
+		if err := fi.contracts.CurrentState().Error(); err != nil {
+			log.Print("State err...", err)
+			return
+		}
+		if n < 0 || !fi.claim(found, key) {
+			continue
+		}
+		fi.logCase(w, Case{Seq: seq[:n+1]}, finding, key)
+	}
+}
+
+//logCase minimizes c and writes it with its finding to w in a single write. If
+//c does not reproduce it is written as is, labeled unreproduced, and not kept.
+func (fi *FuzzInt) logCase(w io.Writer, c Case, finding, key string) {
+	if min, minFinding := fi.Minimize(c, key); minFinding != "" {
+		c, finding = min, minFinding
+		fi.keep(c)
+	} else {
+		finding = "Unreproduced " + finding
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, finding)
+	fmt.Fprintf(&buf, "Seed: %d Iteration: %d", fi.seed, fi.iteration)
+	if h := fi.corpus.Hash(); h != (common.Hash{}) {
+		fmt.Fprintf(&buf, " Corpus: %x", h)
+	}
+	fmt.Fprintln(&buf)
+	fi.GenCaseTable(c, &buf)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,104 @@
+package detectors
+
+import (
+	"io/ioutil"
+	"math/big"
+	"minievm/accounts/abi"
+	"minievm/common"
+	"minievm/common/math"
+	"minievm/core/vm"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//compiled assembles a contract dispatching the methods of abiJSON to the asm
+//items of bodies, keyed by method name, and wraps it like solc output
+func compiled(t *testing.T, abiJSON string, bodies map[string][]interface{}) *SolcOutput {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range parsed.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	runtime := []interface{}{math.BigPow(2, 224), 0, vm.CALLDATALOAD, vm.DIV}
+	for _, name := range names {
+		runtime = append(runtime, vm.DUP1, new(big.Int).SetBytes(parsed.Methods[name].Id()), vm.EQ, ref(name), vm.JUMPI)
+	}
+	runtime = append(runtime, 0, 0, opREVERT)
+	for _, name := range names {
+		runtime = append(append(runtime, label(name)), bodies[name]...)
+	}
+	code := asm(runtime...)
+	var init []byte
+	for offset := -1; offset != len(init); {
+		offset = len(init)
+		init = asm(len(code), offset, 0, vm.CODECOPY, len(code), 0, vm.RETURN)
+	}
+	return &SolcOutput{Contracts: map[string]Contract{
+		"Test": {Abi: abiJSON, Bin: common.Bytes2Hex(append(init, code...))},
+	}}
+}
+
+//returns are the asm items returning the word on top of the stack
+var returns = []interface{}{0, vm.MSTORE, 32, 0, vm.RETURN}
+
+func newTestFuzzer(t *testing.T, out *SolcOutput) *FuzzInt {
+	fi := newFuzzer(newCompiledContract(out), "Test.sol", t.TempDir(), false, 1)
+	if len(fi.contracts.Contracts) != 1 {
+		t.Fatal("test contract not deployed")
+	}
+	return fi
+}
+
+//call returns a call of method name with args from the attacker
+func call(t *testing.T, fi *FuzzInt, name string, args ...interface{}) Tx {
+	calldata, err := fi.maincontract.ABI.Pack(name, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Tx{fi.contracts.ContractAttacker, fi.maincontract.ABI.Methods[name], calldata}
+}
+
+//armed overflows in fire only once arm was called
+func armed(t *testing.T) *SolcOutput {
+	return compiled(t, `[
+		{"type":"function","name":"arm","inputs":[],"outputs":[]},
+		{"type":"function","name":"fire","inputs":[{"name":"x","type":"uint256"}],"outputs":[]}
+	]`, map[string][]interface{}{
+		"arm":  {1, 0, vm.SSTORE, vm.STOP},
+		"fire": {0, vm.SLOAD, vm.ISZERO, ref("disarmed"), vm.JUMPI, math.MaxBig256, 4, vm.CALLDATALOAD, vm.ADD, vm.POP, label("disarmed"), vm.STOP},
+	})
+}
+
+func TestRunCase(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	arm, fire := call(t, fi, "arm"), call(t, fi, "fire", big.NewInt(5))
+	if n, finding, _ := fi.runCase(Case{Seq: Sequence{fire, arm}}); n >= 0 {
+		t.Fatalf("disarmed fire found %q", finding)
+	}
+	n, finding, key := fi.runCase(Case{Seq: Sequence{arm, fire}})
+	if n != 1 || !strings.HasPrefix(finding, "Overflow") || key == "" {
+		t.Fatalf("have call %d finding %q key %q, want the overflow of the second call", n, finding, key)
+	}
+	if v := fi.contracts.GetStorage(common.Hash{}); v != (common.Hash{}) {
+		t.Fatalf("case leaked into the deployed state: %x", v)
+	}
+}
+
+func TestFuzzSequences(t *testing.T) {
+	fi := newTestFuzzer(t, armed(t))
+	fi.FuzzSequences(100)
+	out, err := ioutil.ReadFile(fi.logpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Overflow", "arm()", "fire(uint256)", "Seed: 1"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("log misses %q:\n%s", want, out)
+		}
+	}
+}
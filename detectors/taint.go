@@ -0,0 +1,115 @@
+package detectors
+
+import (
+	"math/big"
+	"minievm/core/vm"
+)
+
+//stackTaint shadows the stack of every open call frame with a taint bit per
+//slot, fed by vm.Inspector's Step and CallEnter. Values pushed by one of the
+//source instructions are tainted, and so is every value computed from a
+//tainted one. Values passing through memory or storage lose their taint.
+type stackTaint struct {
+	sources map[vm.OpCode]bool
+	frames  []*taintFrame // by depth
+	current *taintFrame   // frame of the last step
+}
+
+//taintFrame is the shadow stack of a call frame. The effect of an instruction
+//is applied at the next step of the frame, once the stack it left is known.
+type taintFrame struct {
+	shadow  []bool
+	op      vm.OpCode // last instruction
+	size    int       // stack size before it
+	stepped bool
+}
+
+func newStackTaint(sources ...vm.OpCode) *stackTaint {
+	t := &stackTaint{sources: make(map[vm.OpCode]bool)}
+	for _, op := range sources {
+		t.sources[op] = true
+	}
+	return t
+}
+
+//enter starts an empty shadow stack for the call frame entered at depth
+func (t *stackTaint) enter(depth int) {
+	for len(t.frames) <= depth {
+		t.frames = append(t.frames, nil)
+	}
+	t.frames[depth] = &taintFrame{}
+}
+
+//step catches the frame of ctx up with the stack ctx.Op runs on
+func (t *stackTaint) step(ctx vm.OpContext, stack []*big.Int) {
+	for len(t.frames) <= ctx.Depth {
+		t.frames = append(t.frames, nil)
+	}
+	f := t.frames[ctx.Depth]
+	if f == nil {
+		f = &taintFrame{}
+		t.frames[ctx.Depth] = f
+	}
+	if f.stepped {
+		t.apply(f, len(stack))
+	}
+	if len(f.shadow) != len(stack) {
+		// lost track of the frame, start over untainted
+		f.shadow = make([]bool, len(stack))
+	}
+	f.op, f.size, f.stepped = ctx.Op, len(stack), true
+	t.current = f
+}
+
+//apply updates the shadow stack for the last instruction, which left size items
+func (t *stackTaint) apply(f *taintFrame, size int) {
+	top := len(f.shadow) - 1
+	switch op := f.op; {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		f.shadow = append(f.shadow, f.shadow[top-int(op-vm.DUP1)])
+		return
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		n := top - int(op-vm.SWAP1) - 1
+		f.shadow[top], f.shadow[n] = f.shadow[n], f.shadow[top]
+		return
+	}
+	pushes := 0
+	if pushesValue(f.op) {
+		pushes = 1
+	}
+	pops := f.size - size + pushes
+	if pops < 0 || pops > len(f.shadow) {
+		f.shadow = make([]bool, size)
+		return
+	}
+	tainted := t.sources[f.op]
+	for _, b := range f.shadow[len(f.shadow)-pops:] {
+		tainted = tainted || b
+	}
+	f.shadow = f.shadow[:len(f.shadow)-pops]
+	if pushes > 0 {
+		f.shadow = append(f.shadow, tainted)
+	}
+}
+
+//tainted reports whether the n-th stack item from the top the current
+//instruction runs on is tainted
+func (t *stackTaint) tainted(n int) bool {
+	if t.current == nil {
+		return false
+	}
+	i := len(t.current.shadow) - 1 - n
+	return i >= 0 && t.current.shadow[i]
+}
+
+//pushesValue reports whether op pushes a value, every instruction pushes at most one
+func pushesValue(op vm.OpCode) bool {
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, opInvalid:
+		return false
+	}
+	return true
+}
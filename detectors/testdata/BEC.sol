@@ -0,0 +1,60 @@
+pragma solidity ^0.4.16;
+
+// Trimmed down BeautyChain token: batchTransfer() overflows cnt * _value.
+library SafeMath {
+    function add(uint256 a, uint256 b) internal pure returns (uint256) {
+        uint256 c = a + b;
+        assert(c >= a);
+        return c;
+    }
+
+    function sub(uint256 a, uint256 b) internal pure returns (uint256) {
+        assert(b <= a);
+        return a - b;
+    }
+}
+
+contract BecToken {
+    using SafeMath for uint256;
+
+    string public name = "BeautyChain";
+    string public symbol = "BEC";
+    uint8 public decimals = 18;
+    uint256 public totalSupply;
+
+    mapping (address => uint256) balances;
+
+    event Transfer(address indexed from, address indexed to, uint256 value);
+
+    function BecToken() public {
+        totalSupply = 7000000000 * (10 ** uint256(decimals));
+        balances[msg.sender] = totalSupply;
+    }
+
+    function balanceOf(address _owner) public constant returns (uint256) {
+        return balances[_owner];
+    }
+
+    function transfer(address _to, uint256 _value) public returns (bool) {
+        require(_to != address(0));
+        require(_value > 0 && _value <= balances[msg.sender]);
+        balances[msg.sender] = balances[msg.sender].sub(_value);
+        balances[_to] = balances[_to].add(_value);
+        Transfer(msg.sender, _to, _value);
+        return true;
+    }
+
+    function batchTransfer(address[] _receivers, uint256 _value) public returns (bool) {
+        uint cnt = _receivers.length;
+        uint256 amount = uint256(cnt) * _value;
+        require(cnt > 0 && cnt <= 20);
+        require(_value > 0 && balances[msg.sender] >= amount);
+
+        balances[msg.sender] = balances[msg.sender].sub(amount);
+        for (uint i = 0; i < cnt; i++) {
+            balances[_receivers[i]] = balances[_receivers[i]].add(_value);
+            Transfer(msg.sender, _receivers[i], _value);
+        }
+        return true;
+    }
+}
@@ -0,0 +1,59 @@
+pragma solidity ^0.4.18;
+
+// Trimmed down INT token: sell() overflows amount * sellPrice once the owner
+// sets a large price.
+contract INT {
+    string public name = "INT";
+    string public symbol = "INT";
+    uint8 public decimals = 18;
+    uint256 public totalSupply;
+    uint256 public sellPrice;
+    uint256 public buyPrice;
+    address public owner;
+
+    mapping (address => uint256) public balanceOf;
+
+    event Transfer(address indexed from, address indexed to, uint256 value);
+
+    function INT() public {
+        owner = msg.sender;
+        totalSupply = 1000000 * 10 ** uint256(decimals);
+        balanceOf[msg.sender] = totalSupply;
+        sellPrice = 1;
+        buyPrice = 1;
+    }
+
+    function changename(string _name) public {
+        name = _name;
+    }
+
+    function setPrices(uint256 newSellPrice, uint256 newBuyPrice) public {
+        require(msg.sender == owner);
+        sellPrice = newSellPrice;
+        buyPrice = newBuyPrice;
+    }
+
+    function transfer(address _to, uint256 _value) public {
+        require(balanceOf[msg.sender] >= _value);
+        balanceOf[msg.sender] -= _value;
+        balanceOf[_to] += _value;
+        Transfer(msg.sender, _to, _value);
+    }
+
+    function buy() payable public {
+        uint256 amount = msg.value / buyPrice;
+        require(balanceOf[this] >= amount);
+        balanceOf[this] -= amount;
+        balanceOf[msg.sender] += amount;
+        Transfer(this, msg.sender, amount);
+    }
+
+    function sell(uint256 amount) public {
+        require(this.balance >= amount * sellPrice);
+        require(balanceOf[msg.sender] >= amount);
+        balanceOf[msg.sender] -= amount;
+        balanceOf[this] += amount;
+        Transfer(msg.sender, this, amount);
+        msg.sender.transfer(amount * sellPrice);
+    }
+}
@@ -7,17 +7,23 @@ import (
 	"io/ioutil"
 	"log"
 	"math/big"
+	"minievm/accounts/abi"
 	"minievm/common"
+	"minievm/core/state"
+	"minievm/core/vm"
 	"minievm/detectors"
+	"minievm/ethdb"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"net/http"
 	_ "net/http/pprof"
 
+	"github.com/ethereum/go-ethereum/rpc"
 	_ "github.com/lib/pq"
 )
 
@@ -30,6 +36,36 @@ type SolcOutput struct {
 	Version   string              `json:"version"`
 }
 
+// lastStorageKey logs storage accesses and remembers the last key touched.
+type lastStorageKey struct {
+	vm.NoopInspector
+	key common.Hash
+}
+
+func (l *lastStorageKey) StorageRead(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	log.Printf("%02X, %02X\n", key, value)
+	l.key = key
+}
+
+func (l *lastStorageKey) StorageWrite(ctx vm.OpContext, key common.Hash, value *big.Int) {
+	l.StorageRead(ctx, key, value)
+}
+
+// forkTarget is a contract deployed on a real chain to fuzz instead of a
+// freshly deployed one.
+type forkTarget struct {
+	backend state.Backend
+	addr    common.Address
+}
+
+// checkpointTarget saves the prepared state before fuzzing, or fuzzes on a
+// state saved before instead of the deployed one.
+type checkpointTarget struct {
+	savepath string
+	loadpath string
+	id       common.Hash
+}
+
 func RunOverflowDetector(contractName, codeHex, abi, functionName string, initAttacker, initVictims bool) {
 	ofd := &detectors.OverFlowDetector{}
 	ofd.Init(contractName, abi)
@@ -68,16 +104,11 @@ func CallFunction(code []byte, functionName string) {
 		if ok {
 			code := common.Hex2Bytes(contract.Bin)
 			ofd.CreateContract(ofd.Owner, code, big.NewInt(0))
-			// ofd.SetCallback(detectors.EVMCallback)
-			var key common.Hash
-			ofd.SetCallback(func(args []interface{}) {
-				loc := args[0].(common.Hash)
-				val := args[1].(*big.Int)
-				log.Printf("%02X, %02X\n", loc, val)
-				key = loc
-			})
+			// ofd.AddInspector(detectors.StorageLogger{})
+			sl := &lastStorageKey{}
+			ofd.AddInspector(sl)
 			ofd.CallFunctionStub(functionName)
-			log.Printf("Get State: %02X\n", ofd.GetState(key))
+			log.Printf("Get State: %02X\n", ofd.GetState(sl.key))
 		}
 	}
 }
@@ -152,23 +183,120 @@ func main() {
 	contractPath := flag.String("p", "./", "path to file or folder")
 	logPath := flag.String("lp", "./fuzz_log", "fuzzer's log path")
 	solcPath := flag.String("sp", "solc", "solc path")
+	allocPath := flag.String("alloc", "", "genesis alloc file to seed the state with")
+	dumpAllocPath := flag.String("dumpalloc", "", "genesis alloc file to write the prepared state to before fuzzing, to seed later runs with -alloc")
+	rpcURL := flag.String("rpc", "", "JSON-RPC endpoint to fork chain state from")
+	forkBlock := flag.Uint64("block", 0, "block number to fork chain state at")
+	forkAddr := flag.String("at", "", "address of the deployed contract to fuzz when forking")
+	cachePath := flag.String("cache", "./fork_cache", "cache path for forked chain state")
+	seqRounds := flag.Int("seqs", 0, "number of multi-transaction sequences to fuzz after single calls")
+	maxLength := flag.Int("maxlen", abi.FuzzMaxLength, "max number of elements of fuzzed arrays, bytes and strings")
+	corpusDir := flag.String("corpus", "", "directory to load and save the fuzz corpus of each contract in, to resume campaigns")
+	invariantPrefix := flag.String("invariants", "", "prefix of the bool returning functions to check as invariants after every sequence call, e.g. "+detectors.DefaultInvariantPrefix)
+	checkERC20 := flag.Bool("erc20", false, "check the ERC20 properties of tokens after every sequence call")
+	workers := flag.Int("j", 1, "number of fuzzing workers, a single contract is split between them")
+	savePath := flag.String("save", "", "LevelDB path to checkpoint the prepared state into before fuzzing, its id is logged")
+	loadPath := flag.String("load", "", "LevelDB path to load the state to fuzz from, see -save")
+	checkpointID := flag.String("checkpoint", "", "id of the checkpoint to load with -load")
+	autoFund := flag.String("fund", "", "balance in wei every account starts with the first time it is seen (default: none)")
+	seed := flag.Int64("seed", 0, "seed of all fuzzing randomness, rerun with the logged seed to replay a finding; with -corpus the loaded corpus must match the logged corpus hash too (default: time based)")
 	flag.Parse()
+	abi.FuzzMaxLength = *maxLength
+	if *autoFund != "" {
+		fund, ok := new(big.Int).SetString(*autoFund, 0)
+		if !ok || fund.Sign() < 0 {
+			log.Fatalf("invalid -fund %q", *autoFund)
+		}
+		detectors.AutoFund = fund
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Seed: %d\n", *seed)
+	if (*invariantPrefix != "" || *checkERC20) && *seqRounds == 0 {
+		// invariants are checked on sequences
+		*seqRounds = detectors.StorageMutateNum * detectors.MaxRuns
+	}
 
-	dispatcher(*solcPath, *contractPath, *logPath)
-}
+	var fork *forkTarget
+	if *rpcURL != "" {
+		client, err := rpc.Dial(*rpcURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cache, err := ethdb.NewLDBDatabase(*cachePath, 16, 16)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cache.Close()
+		fork = &forkTarget{state.NewRPCBackend(client, *forkBlock, cache), common.HexToAddress(*forkAddr)}
+	}
 
-func dispatcher(solcpath, contractpath, logpath string) {
-	tasks := make(chan *detectors.FuzzInt, 16)
-	var wg sync.WaitGroup
-	for i := 0; i < 1; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for task := range tasks {
+	var checkpoint *checkpointTarget
+	if *savePath != "" || *loadPath != "" {
+		if *loadPath != "" && *checkpointID == "" {
+			log.Fatal("-load needs the -checkpoint id logged by -save")
+		}
+		checkpoint = &checkpointTarget{*savePath, *loadPath, common.HexToHash(*checkpointID)}
+	}
 
-				task.FuzzContracts()
+	dispatcher(*solcPath, *contractPath, *logPath, *allocPath, *dumpAllocPath, fork, checkpoint, *seqRounds, *seed, *workers, *corpusDir, *invariantPrefix, *checkERC20)
+}
+
+func dispatcher(solcpath, contractpath, logpath, allocpath, dumpallocpath string, fork *forkTarget, checkpoint *checkpointTarget, seqrounds int, seed int64, workers int, corpusdir, invariantprefix string, checkerc20 bool) {
+	prepare := func(task *detectors.FuzzInt) {
+		if fork != nil {
+			if err := task.AttachRemote(fork.backend, fork.addr); err != nil {
+				log.Fatal(err)
 			}
-		}()
+		}
+		if checkpoint != nil && checkpoint.loadpath != "" {
+			if err := task.LoadStates(checkpoint.loadpath, checkpoint.id); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if allocpath != "" {
+			if err := task.LoadAlloc(allocpath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if invariantprefix != "" {
+			log.Printf("Invariants: %d\n", task.SetInvariantPrefix(invariantprefix))
+		}
+		if checkerc20 && !task.EnableERC20() {
+			log.Print("Not an ERC20 token, skipping its properties")
+		}
+	}
+	save := func(task *detectors.FuzzInt) {
+		if checkpoint != nil && checkpoint.savepath != "" {
+			id, err := task.SaveStates(checkpoint.savepath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Checkpoint: %x\n", id)
+		}
+		if dumpallocpath != "" {
+			if err := task.SaveAlloc(dumpallocpath); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	openCorpus := func(task *detectors.FuzzInt) {
+		if corpusdir != "" {
+			if err := task.OpenCorpus(corpusdir); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Corpus: %x\n", task.CorpusHash())
+		}
+	}
+	run := func(task *detectors.FuzzInt) {
+		prepare(task)
+		save(task)
+		openCorpus(task)
+		task.FuzzContracts()
+		if seqrounds > 0 {
+			task.FuzzSequences(seqrounds)
+		}
 	}
 
 	fi, err := os.Stat(contractpath)
@@ -178,25 +306,53 @@ func dispatcher(solcpath, contractpath, logpath string) {
 	}
 	switch mode := fi.Mode(); {
 	case mode.IsDir():
+		if checkpoint != nil {
+			log.Fatal("-save and -load checkpoint a single contract, not a directory")
+		}
+		if fork != nil {
+			log.Fatal("-at names a single deployed contract, not a directory")
+		}
+		if dumpallocpath != "" {
+			log.Fatal("-dumpalloc writes the state of a single contract, not a directory")
+		}
 		files, err := ioutil.ReadDir(contractpath)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// count := len(files)
-		// bar := pb.StartNew(count)
-
+		// every worker compiles, deploys and fuzzes its contracts on its own EVM
+		paths := make(chan string, 16)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range paths {
+					log.Printf("Now Fuzzing... %s\n", path.Base(p))
+					run(detectors.NewContractFuzzer(solcpath, p, logpath, false, seed))
+				}
+			}()
+		}
 		for _, f := range files {
-			// bar.Increment()
-			log.Printf("Now Fuzzing... %s\n", f.Name())
-			tasks <- detectors.NewContractFuzzer(solcpath, path.Join(contractpath, f.Name()), logpath, false)
-			// runtime.GC()
-			// common.PrintMemUsage()
+			paths <- path.Join(contractpath, f.Name())
 		}
+		close(paths)
+		wg.Wait()
 	case mode.IsRegular():
-		tasks <- detectors.NewContractFuzzer(solcpath, contractpath, logpath, true)
+		if workers <= 1 {
+			run(detectors.NewContractFuzzer(solcpath, contractpath, logpath, true, seed))
+			return
+		}
+		campaign := detectors.NewCampaign(solcpath, contractpath, logpath, seed, workers)
+		for _, task := range campaign.Workers {
+			prepare(task)
+		}
+		// the workers start from the same state
+		save(campaign.Workers[0])
+		// the workers share the corpus
+		openCorpus(campaign.Workers[0])
+		if err := campaign.Run(seqrounds); err != nil {
+			log.Fatal(err)
+		}
 	}
-
-	wg.Wait()
-	close(tasks)
 }